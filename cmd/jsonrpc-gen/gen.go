@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/Lysander66/zephyr/pkg/jsonrpc"
+)
+
+const componentsSchemas = "#/components/schemas/"
+
+// generator writes a Go client for the methods of an OpenRPC document.
+type generator struct {
+	buf   bytes.Buffer
+	types bytes.Buffer // params types, written after the methods
+
+	imports map[string]bool
+	refs    map[string]bool // component schemas used by the generated code
+}
+
+func generate(doc *jsonrpc.OpenRPC, pkg, source string) ([]byte, error) {
+	g := &generator{
+		imports: map[string]bool{"context": true},
+		refs:    make(map[string]bool),
+	}
+
+	g.clientType()
+	for _, m := range doc.Methods {
+		if strings.HasPrefix(m.Name, "rpc.") {
+			// reserved for the protocol, e.g. rpc.discover
+			continue
+		}
+		if err := g.method(m); err != nil {
+			return nil, fmt.Errorf("method %s: %w", m.Name, err)
+		}
+	}
+	g.buf.Write(g.types.Bytes())
+	if err := g.components(doc); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "// Code generated by jsonrpc-gen from %s. DO NOT EDIT.\n\n", source)
+	if doc.Info.Title != "" {
+		fmt.Fprintf(&body, "// Package %s is a client of %s %s.\n", pkg, doc.Info.Title, doc.Info.Version)
+	}
+	fmt.Fprintf(&body, "package %s\n\nimport (\n", pkg)
+	for _, path := range slices.Sorted(maps.Keys(g.imports)) {
+		fmt.Fprintf(&body, "\t%q\n", path)
+	}
+	body.WriteString("\n\t\"github.com/Lysander66/zephyr/pkg/jsonrpc\"\n)\n\n")
+	body.Write(g.buf.Bytes())
+
+	src, err := format.Source(body.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, body.Bytes())
+	}
+	return src, nil
+}
+
+func (g *generator) clientType() {
+	g.buf.WriteString(`// Client calls the methods of the API.
+type Client struct {
+	rpc jsonrpc.Caller
+}
+
+// NewClient returns a Client making calls with rpc, a *jsonrpc.Client or a *jsonrpc.Conn.
+func NewClient(rpc jsonrpc.Caller) *Client {
+	return &Client{rpc: rpc}
+}
+
+`)
+}
+
+func (g *generator) method(m jsonrpc.Method) error {
+	name := exportName(m.Name)
+	result := "any"
+	if m.Result != nil && m.Result.Schema != nil {
+		result = g.goType(m.Result.Schema)
+	}
+
+	var (
+		args       []string
+		paramsType = "any"
+		params     = "nil"
+	)
+	switch {
+	case len(m.Params) == 0:
+	case m.ParamsValue:
+		paramsType = g.goType(m.Params[0].Schema)
+		params = "params"
+		args = append(args, "params "+paramsType)
+	case m.ParamStructure == "by-name":
+		paramsType = name + "Params"
+		params = "params"
+		args = append(args, "params "+paramsType)
+		fmt.Fprintf(&g.types, "// %s are the params of %s.\ntype %s struct {\n", paramsType, m.Name, paramsType)
+		for _, p := range m.Params {
+			g.field(&g.types, p.Name, p.Schema, p.Required)
+		}
+		g.types.WriteString("}\n\n")
+	default:
+		// by position, one argument per param
+		paramsType = "[]any"
+		var names []string
+		for _, p := range m.Params {
+			arg := argName(p.Name)
+			if slices.Contains(names, arg) {
+				return fmt.Errorf("duplicate param %s", p.Name)
+			}
+			names = append(names, arg)
+			args = append(args, arg+" "+g.goType(p.Schema))
+		}
+		params = "[]any{" + strings.Join(names, ", ") + "}"
+	}
+
+	if m.Summary != "" {
+		fmt.Fprintf(&g.buf, "// %s %s\n", name, m.Summary)
+	} else {
+		fmt.Fprintf(&g.buf, "// %s calls %s.\n", name, m.Name)
+	}
+	fmt.Fprintf(&g.buf, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(append([]string{"ctx context.Context"}, args...), ", "), result)
+	fmt.Fprintf(&g.buf, "\treturn jsonrpc.Call[%s, %s](ctx, c.rpc, %q, %s)\n}\n\n", paramsType, result, m.Name, params)
+	return nil
+}
+
+// components writes the types of the component schemas used by the generated code, and the ones they use.
+func (g *generator) components(doc *jsonrpc.OpenRPC) error {
+	done := make(map[string]bool)
+	typeNames := make(map[string]string) // schema names by Go type name
+	for {
+		var pending []string
+		for name := range g.refs {
+			if !done[name] {
+				pending = append(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		slices.Sort(pending)
+		for _, name := range pending {
+			done[name] = true
+			var schema *jsonrpc.Schema
+			if doc.Components != nil {
+				schema = doc.Components.Schemas[name]
+			}
+			if schema == nil {
+				return fmt.Errorf("schema %s is not in the components", name)
+			}
+			typeName := exportName(name)
+			if other, ok := typeNames[typeName]; ok {
+				return fmt.Errorf("schemas %s and %s are both named %s in Go", other, name, typeName)
+			}
+			typeNames[typeName] = name
+			fmt.Fprintf(&g.buf, "type %s %s\n\n", typeName, g.goType(schema))
+		}
+	}
+}
+
+// field writes a struct field. Optional fields referencing a component are pointers, which allows recursive types.
+func (g *generator) field(buf *bytes.Buffer, name string, s *jsonrpc.Schema, required bool) {
+	tag := name
+	typ := g.goType(s)
+	if !required {
+		tag += ",omitempty"
+		if s != nil && s.Ref != "" {
+			typ = "*" + typ
+		}
+	}
+	fmt.Fprintf(buf, "\t%s %s `json:%q`\n", exportName(name), typ, tag)
+}
+
+// goType returns the Go type of values of schema s.
+func (g *generator) goType(s *jsonrpc.Schema) string {
+	if s == nil {
+		return "any"
+	}
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, componentsSchemas)
+		g.refs[name] = true
+		return exportName(name)
+	}
+
+	switch s.Type {
+	case "boolean":
+		return "bool"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "string":
+		switch s.Format {
+		case "date-time":
+			g.imports["time"] = true
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "array":
+		return "[]" + g.goType(s.Items)
+	case "object":
+		if len(s.Properties) > 0 {
+			var buf bytes.Buffer
+			buf.WriteString("struct {\n")
+			for _, name := range slices.Sorted(maps.Keys(s.Properties)) {
+				g.field(&buf, name, s.Properties[name], slices.Contains(s.Required, name))
+			}
+			buf.WriteString("}")
+			return buf.String()
+		}
+		if s.AdditionalProperties != nil {
+			return "map[string]" + g.goType(s.AdditionalProperties)
+		}
+		return "map[string]any"
+	}
+	return "any"
+}
+
+// exportName turns a method, schema or property name into an exported Go identifier,
+// e.g. "aria2.addUri" into "Aria2AddUri".
+func exportName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteByte('X')
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
+}
+
+// argName turns a param name into an argument name, that is not a keyword.
+func argName(name string) string {
+	s := []rune(exportName(name))
+	s[0] = unicode.ToLower(s[0])
+	arg := string(s)
+	if token.IsKeyword(arg) || arg == "ctx" || arg == "c" {
+		arg += "_"
+	}
+	return arg
+}
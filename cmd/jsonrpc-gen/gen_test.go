@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lysander66/zephyr/pkg/jsonrpc"
+)
+
+type download struct {
+	GID     string    `json:"gid"`
+	URIs    []string  `json:"uris"`
+	Started time.Time `json:"started,omitempty"`
+}
+
+type addParams struct {
+	URIs    []string          `json:"uris"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+type queue struct{}
+
+func (queue) Add(context.Context, addParams) (*download, error)  { return nil, nil }
+func (queue) List(context.Context, []string) ([]download, error) { return nil, nil }
+func (queue) Remove(context.Context, [2]string) (bool, error)    { return false, nil }
+
+func TestGenerate(t *testing.T) {
+	s := jsonrpc.NewServer(jsonrpc.ServerInfo(jsonrpc.Info{Title: "queue", Version: "1.0.0"}))
+	if err := s.RegisterService("queue", queue{}); err != nil {
+		t.Fatal(err)
+	}
+	// a recursive type
+	s.RegisterFunc("queue.schema", func(context.Context, []any) (*jsonrpc.Schema, error) { return nil, nil })
+	doc := s.OpenRPC()
+	// a by-position method, as written by hand
+	doc.Methods = append(doc.Methods, jsonrpc.Method{
+		Name:           "queue.move",
+		Summary:        "moves a download in the queue.",
+		ParamStructure: "by-position",
+		Params: []*jsonrpc.ContentDescriptor{
+			{Name: "gid", Schema: &jsonrpc.Schema{Type: "string"}},
+			{Name: "type", Schema: &jsonrpc.Schema{Type: "integer"}},
+		},
+		Result: &jsonrpc.ContentDescriptor{Name: "position", Schema: &jsonrpc.Schema{Type: "integer"}},
+	})
+
+	src, err := generate(doc, "queueclient", "openrpc.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(src)
+	t.Log(code)
+	// ignore the alignment of struct fields
+	code = strings.Join(strings.Fields(code), " ")
+
+	for _, want := range []string{
+		"// Code generated by jsonrpc-gen from openrpc.json. DO NOT EDIT.",
+		"package queueclient",
+		`"time"`,
+		"func (c *Client) QueueAdd(ctx context.Context, params QueueAddParams) (Download, error) {",
+		`return jsonrpc.Call[QueueAddParams, Download](ctx, c.rpc, "queue.add", params)`,
+		"func (c *Client) QueueList(ctx context.Context, params []string) ([]Download, error) {",
+		"// QueueMove moves a download in the queue.",
+		"func (c *Client) QueueMove(ctx context.Context, gid string, type_ int64) (int64, error) {",
+		`return jsonrpc.Call[[]any, int64](ctx, c.rpc, "queue.move", []any{gid, type_})`,
+		"Options map[string]string `json:\"options,omitempty\"`",
+		"type Download struct {",
+		"Started time.Time `json:\"started,omitempty\"`",
+		"Items *Schema `json:\"items,omitempty\"`",
+	} {
+		if !strings.Contains(code, strings.Join(strings.Fields(want), " ")) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(code, "RpcDiscover") {
+		t.Error("rpc.discover is generated")
+	}
+}
+
+func TestGenerate_SameTypeName(t *testing.T) {
+	item := &jsonrpc.Schema{Type: "object", Properties: map[string]*jsonrpc.Schema{"id": {Type: "string"}}}
+	doc := &jsonrpc.OpenRPC{
+		Methods: []jsonrpc.Method{{
+			Name:           "items",
+			ParamStructure: "by-name",
+			Params: []*jsonrpc.ContentDescriptor{
+				{Name: "a", Schema: &jsonrpc.Schema{Ref: componentsSchemas + "a.Item"}},
+				{Name: "b", Schema: &jsonrpc.Schema{Ref: componentsSchemas + "AItem"}},
+			},
+		}},
+		Components: &jsonrpc.Components{Schemas: map[string]*jsonrpc.Schema{"a.Item": item, "AItem": item}},
+	}
+	if _, err := generate(doc, "items", "openrpc.json"); err == nil || !strings.Contains(err.Error(), "both named AItem") {
+		t.Errorf("err = %v, want a name collision", err)
+	}
+}
+
+func TestExportName(t *testing.T) {
+	tests := map[string]string{
+		"aria2.addUri":  "Aria2AddUri",
+		"get_user-info": "GetUserInfo",
+		"2fa":           "X2fa",
+		"":              "X",
+		"rpc.discover":  "RpcDiscover",
+	}
+	for name, want := range tests {
+		if got := exportName(name); got != want {
+			t.Errorf("exportName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Command jsonrpc-gen generates a typed Go client from an OpenRPC document, such as the one
+// served by rpc.discover of a jsonrpc.Server.
+//
+//	jsonrpc-gen -in openrpc.json -pkg queue -out queue/client.go
+//	jsonrpc-gen -in http://localhost:8080/rpc -pkg queue -out queue/client.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Lysander66/zephyr/pkg/jsonrpc"
+)
+
+func main() {
+	var (
+		in  = flag.String("in", "openrpc.json", "OpenRPC document file, or JSON-RPC endpoint to call rpc.discover on")
+		out = flag.String("out", "", "output file, stdout if empty")
+		pkg = flag.String("pkg", "client", "package name of the generated code")
+	)
+	flag.Parse()
+
+	doc, err := load(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(doc, *pkg, *in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func load(in string) (*jsonrpc.OpenRPC, error) {
+	for _, scheme := range []string{"http://", "https://", "ws://", "wss://", "tcp://"} {
+		if strings.HasPrefix(in, scheme) {
+			client := jsonrpc.NewClient(in)
+			defer client.Close()
+			return jsonrpc.Call[any, *jsonrpc.OpenRPC](context.Background(), client, "rpc.discover", nil)
+		}
+	}
+
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return nil, err
+	}
+	var doc jsonrpc.OpenRPC
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", in, err)
+	}
+	return &doc, nil
+}
@@ -4,8 +4,11 @@ go 1.23
 
 require (
 	github.com/bluenviron/gohlslib v1.4.0
+	github.com/golang/snappy v1.0.0
 	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.18.0
 	github.com/tidwall/gjson v1.17.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	github.com/yapingcat/gomedia v0.0.0-20240906162731-17feea57090c
 	golang.org/x/crypto v0.27.0
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394
@@ -15,5 +18,6 @@ require (
 require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 )
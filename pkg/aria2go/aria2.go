@@ -3,16 +3,11 @@ package aria2go
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/url"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
 	"github.com/Lysander66/zephyr/pkg/jsonrpc"
-	"github.com/gorilla/websocket"
-	"github.com/tidwall/gjson"
 )
 
 const (
@@ -105,186 +100,150 @@ type Notifier interface {
 }
 
 type Client struct {
-	secret    string
-	rpcClient *jsonrpc.Client
+	secret     string
+	rpcClient  *jsonrpc.Client
+	rpcOptions []jsonrpc.Option
 }
 
 type Option func(o *Client)
 
-func NewClient(endpoint, rpcSecret string, notifier Notifier) (*Client, error) {
+// RPCOptions sets options of the underlying jsonrpc.Client.
+func RPCOptions(opts ...jsonrpc.Option) Option {
+	return func(o *Client) { o.rpcOptions = append(o.rpcOptions, opts...) }
+}
+
+// XMLRPC makes the client speak XML-RPC, to an endpoint such as http://localhost:6800/rpc.
+// Notifications are only available over JSON-RPC.
+func XMLRPC() Option {
+	return RPCOptions(jsonrpc.XMLRPC())
+}
+
+// NewClient creates a client of the aria2 RPC interface at endpoint, e.g. http://localhost:6800/jsonrpc.
+// With a notifier, the client connects over WebSocket to receive notifications, and calls go over the same connection.
+func NewClient(endpoint, rpcSecret string, notifier Notifier, opts ...Option) (*Client, error) {
 	u, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	c := &Client{
-		secret:    rpcSecret,
-		rpcClient: jsonrpc.NewClient(endpoint),
+	if notifier != nil {
+		switch u.Scheme {
+		case "http":
+			u.Scheme = "ws"
+		case "https":
+			u.Scheme = "wss"
+		}
+		endpoint = u.String()
+	}
+
+	c := &Client{secret: rpcSecret}
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.rpcClient = jsonrpc.NewClient(endpoint, c.rpcOptions...)
 
 	if notifier != nil {
-		u.Scheme = "ws"
-		go c.setNotifier(u.String(), notifier)
+		c.setNotifier(notifier)
 	}
 	return c, nil
 }
 
-func (c *Client) setNotifier(endpoint string, notifier Notifier) {
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, os.Kill, syscall.SIGTERM)
-
-	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
-	if err != nil {
-		slog.Error("websocket.Dial", "err", err)
-		return
-	}
-	defer conn.Close()
-
-	done := make(chan struct{})
-
-	go func() {
-		defer close(done)
-		for {
-			_, message, err := conn.ReadMessage()
-			if err != nil {
-				slog.Error("read:", "err", err)
-				return
-			}
-			method := gjson.GetBytes(message, "method").String()
-			if method != "" {
-				var params []Event
-				if err = json.Unmarshal([]byte(gjson.GetBytes(message, "params").String()), &params); err != nil {
-					slog.Error("read:", "err", err, "message", message)
-					return
-				}
-				switch method {
-				case "aria2.onDownloadStart":
-					notifier.OnDownloadStart(params)
-				case "aria2.onDownloadPause":
-					notifier.OnDownloadPause(params)
-				case "aria2.onDownloadStop":
-					notifier.OnDownloadStop(params)
-				case "aria2.onDownloadComplete":
-					notifier.OnDownloadComplete(params)
-				case "aria2.onDownloadError":
-					notifier.OnDownloadError(params)
-				case "aria2.onBtDownloadComplete":
-					notifier.OnBtDownloadComplete(params)
-				}
-			} else {
-				slog.Info("recv: " + string(message))
-			}
-		}
-	}()
-
-	for {
-		select {
-		case <-done:
-			return
-		case <-interrupt:
-			slog.Info("interrupt")
-			// Cleanly close the connection by sending a close message and then waiting (with timeout) for the server to close the connection.
-			err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-			if err != nil {
-				slog.Error("write close:", "err", err)
+func (c *Client) setNotifier(notifier Notifier) {
+	handlers := map[string]func([]Event){
+		"aria2.onDownloadStart":      notifier.OnDownloadStart,
+		"aria2.onDownloadPause":      notifier.OnDownloadPause,
+		"aria2.onDownloadStop":       notifier.OnDownloadStop,
+		"aria2.onDownloadComplete":   notifier.OnDownloadComplete,
+		"aria2.onDownloadError":      notifier.OnDownloadError,
+		"aria2.onBtDownloadComplete": notifier.OnBtDownloadComplete,
+	}
+
+	for method, handler := range handlers {
+		c.rpcClient.HandleNotification(method, func(params json.RawMessage) {
+			var events []Event
+			if err := json.Unmarshal(params, &events); err != nil {
+				slog.Error("aria2: invalid notification", "err", err, "method", method, "params", string(params))
 				return
 			}
-			select {
-			case <-done:
-			case <-time.After(time.Second):
-			}
-			return
-		}
+			handler(events)
+		})
 	}
 }
 
+// Close closes the WebSocket connection of a client created with a notifier.
+func (c *Client) Close() error {
+	return c.rpcClient.Close()
+}
+
 func (c *Client) token() string {
 	return "token:" + c.secret
 }
 
-// AddURI
-// https://aria2.github.io/manual/en/html/aria2c.html#aria2.addUri
-func (c *Client) AddURI(ctx context.Context, uris []string, options ...any) (string, error) {
+// params prepends the secret token to args, if any.
+func (c *Client) params(args ...any) []any {
 	var params []any
 	if c.secret != "" {
 		params = append(params, c.token())
 	}
-	params = append(params, uris)
-	if options != nil {
-		params = append(params, options...)
-	}
-
-	req := jsonrpc.NewRequest(methodAddUri, params, time.Now().UnixNano())
-	resp, err := c.rpcClient.Call(ctx, req)
-	if err != nil {
-		return "", err
-	}
+	return append(params, args...)
+}
 
-	return resp.GetString()
+// AddURI
+// https://aria2.github.io/manual/en/html/aria2c.html#aria2.addUri
+func (c *Client) AddURI(ctx context.Context, uris []string, options ...any) (string, error) {
+	return jsonrpc.Call[[]any, string](ctx, c.rpcClient, methodAddUri, c.params(append([]any{uris}, options...)...))
 }
 
 // TellStatus | active waiting paused error complete removed
 // https://aria2.github.io/manual/en/html/aria2c.html#aria2.tellStatus
-func (c *Client) TellStatus(ctx context.Context, gid string, keys ...string) (statusInfo StatusInfo, err error) {
-	var params []any
-	if c.secret != "" {
-		params = append(params, c.token())
-	}
-	params = append(params, gid)
+func (c *Client) TellStatus(ctx context.Context, gid string, keys ...string) (StatusInfo, error) {
+	params := c.params(gid)
 	if keys != nil {
 		params = append(params, keys)
 	}
-	req := jsonrpc.NewRequest(methodTellStatus, params, time.Now().UnixNano())
-	resp, err := c.rpcClient.Call(ctx, req)
-	if err != nil {
-		return
-	}
-
-	err = resp.GetAny(&statusInfo)
-	return
+	return jsonrpc.Call[[]any, StatusInfo](ctx, c.rpcClient, methodTellStatus, params)
 }
 
-func (c *Client) TellStopped(ctx context.Context, offset, num int, keys ...string) (list []StatusInfo, err error) {
-	var params []any
-	if c.secret != "" {
-		params = append(params, c.token())
-	}
-	params = append(params, offset, num)
-	if keys != nil {
-		params = append(params, keys)
+// TellStatuses gets the status of several downloads in a single batch call.
+func (c *Client) TellStatuses(ctx context.Context, gids []string, keys ...string) ([]StatusInfo, error) {
+	requests := make([]*jsonrpc.Request, len(gids))
+	for i, gid := range gids {
+		params := c.params(gid)
+		if keys != nil {
+			params = append(params, keys)
+		}
+		requests[i] = jsonrpc.NewRequest(methodTellStatus, params, jsonrpc.NextID())
 	}
-	req := jsonrpc.NewRequest(methodTellStopped, params, time.Now().UnixNano())
-	resp, err := c.rpcClient.Call(ctx, req)
+
+	responses, err := c.rpcClient.CallBatch(ctx, requests)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	err = resp.GetAny(&list)
-	return
+	list := make([]StatusInfo, len(responses))
+	for i, resp := range responses {
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s: %w", gids[i], resp.Error)
+		}
+		if err = resp.GetAny(&list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
 }
 
-func (c *Client) GetGlobalStat(ctx context.Context) (stat GlobalStat, err error) {
-	var params []any
-	if c.secret != "" {
-		params = append(params, c.token())
-	}
-	req := jsonrpc.NewRequest(methodGetGlobalStat, params, time.Now().UnixNano())
-	resp, err := c.rpcClient.Call(ctx, req)
-	if err != nil {
-		return
+func (c *Client) TellStopped(ctx context.Context, offset, num int, keys ...string) ([]StatusInfo, error) {
+	params := c.params(offset, num)
+	if keys != nil {
+		params = append(params, keys)
 	}
-
-	err = resp.GetAny(&stat)
-	return
+	return jsonrpc.Call[[]any, []StatusInfo](ctx, c.rpcClient, methodTellStopped, params)
 }
 
-func (c *Client) ListMethods(ctx context.Context) (methods []string, err error) {
-	req := jsonrpc.NewRequest(methodListMethods, nil, time.Now().UnixNano())
-	resp, err := c.rpcClient.Call(ctx, req)
-	if err != nil {
-		return
-	}
+func (c *Client) GetGlobalStat(ctx context.Context) (GlobalStat, error) {
+	return jsonrpc.Call[[]any, GlobalStat](ctx, c.rpcClient, methodGetGlobalStat, c.params())
+}
 
-	err = resp.GetAny(&methods)
-	return
+func (c *Client) ListMethods(ctx context.Context) ([]string, error) {
+	return jsonrpc.Call[[]any, []string](ctx, c.rpcClient, methodListMethods, nil)
 }
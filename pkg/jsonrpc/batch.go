@@ -0,0 +1,136 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMissingResponse is returned by CallBatch when the server did not reply to some requests of a batch.
+var ErrMissingResponse = errors.New("jsonrpc: missing response")
+
+// CallBatch sends requests as a single batch (spec section 6) and returns the responses in the order of requests.
+// Responses are matched to requests by ID, whatever order the server replies in.
+//
+// Requests without ID are notifications, their response is nil.
+// If the server rejects the whole batch with a single error object, that *Error is returned.
+// If some responses are missing, the available ones are returned along with an error wrapping ErrMissingResponse.
+func (c *Client) CallBatch(ctx context.Context, requests []*Request) ([]*Response, error) {
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("jsonrpc: empty batch")
+	}
+	if c.conn != nil {
+		return c.conn.CallBatch(ctx, requests)
+	}
+	if c.xml {
+		return c.callBatchXML(ctx, requests)
+	}
+
+	index := make(map[string]int, len(requests))
+	for i, req := range requests {
+		if req.ID == nil {
+			continue
+		}
+		key, err := idKey(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := index[key]; ok {
+			return nil, fmt.Errorf("jsonrpc: duplicate id %s in batch", key)
+		}
+		index[key] = i
+	}
+
+	body, err := c.post(ctx, requests, "batch")
+	if err != nil {
+		return nil, err
+	}
+
+	return matchBatch(body, requests, index)
+}
+
+// matchBatch decodes a batch reply and orders its responses as requests.
+func matchBatch(body []byte, requests []*Request, index map[string]int) ([]*Response, error) {
+	responses := make([]*Response, len(requests))
+
+	body = bytes.TrimSpace(body)
+	switch {
+	case len(body) == 0:
+		// a batch of notifications gets no reply
+	case body[0] == '[':
+		var raws []json.RawMessage
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON response for batch: %v", err)
+		}
+		for _, raw := range raws {
+			if err := matchResponse(raw, responses, index); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		// A single object is either the error for the whole batch, or the reply to a batch of one request.
+		var resp Response
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON response for batch: %v", err)
+		}
+		if resp.ID == nil && resp.Error != nil {
+			return nil, resp.Error
+		}
+		if err := matchResponse(body, responses, index); err != nil {
+			return nil, err
+		}
+	}
+
+	var missing []string
+	for key, i := range index {
+		if responses[i] == nil {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return responses, fmt.Errorf("%w for id %s", ErrMissingResponse, strings.Join(missing, ", "))
+	}
+	return responses, nil
+}
+
+func matchResponse(raw json.RawMessage, responses []*Response, index map[string]int) error {
+	var msg struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return fmt.Errorf("failed to decode JSON response for batch: %v", err)
+	}
+
+	i, ok := index[string(msg.ID)]
+	if !ok {
+		var resp Response
+		if err := json.Unmarshal(raw, &resp); err == nil && resp.Error != nil {
+			// e.g. an invalid request of the batch, that the server could not read the id of
+			return fmt.Errorf("jsonrpc: batch error for id %s: %w", msg.ID, resp.Error)
+		}
+		return fmt.Errorf("jsonrpc: unexpected response id %s in batch", msg.ID)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return fmt.Errorf("failed to decode JSON response for batch: %v", err)
+	}
+	responses[i] = &resp
+	return nil
+}
+
+// idKey returns the JSON encoding of id, which is how it comes back in responses.
+func idKey(id any) (string, error) {
+	b, err := json.Marshal(id)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,82 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// batchServer replies to a batch in reverse order, echoing the params of each request.
+func batchServer(t *testing.T, reply func(reqs []map[string]any) any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []map[string]any
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&reqs); err != nil {
+			t.Errorf("not a batch: %v", err)
+		}
+		if v := reply(reqs); v != nil {
+			json.NewEncoder(w).Encode(v)
+		}
+	}))
+}
+
+func TestClient_CallBatch(t *testing.T) {
+	ts := batchServer(t, func(reqs []map[string]any) any {
+		var resps []map[string]any
+		for i := len(reqs) - 1; i >= 0; i-- {
+			if id, ok := reqs[i]["id"]; ok {
+				resps = append(resps, map[string]any{"jsonrpc": version2, "id": id, "result": reqs[i]["params"]})
+			}
+		}
+		return resps
+	})
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	requests := []*Request{
+		NewRequest("echo", []any{"a"}, 1729000000000000001),
+		{Version: version2, Method: "notify", Params: []any{"b"}},
+		NewRequest("echo", []any{"c"}, "c"),
+	}
+
+	responses, err := client.CallBatch(context.Background(), requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[1] != nil {
+		t.Errorf("notification got a response: %+v", responses[1])
+	}
+	for i, want := range map[int]string{0: "a", 2: "c"} {
+		var got []string
+		if err := responses[i].GetAny(&got); err != nil || got[0] != want {
+			t.Errorf("responses[%d] = %v, %v, want %s", i, got, err, want)
+		}
+	}
+}
+
+func TestClient_CallBatchErrors(t *testing.T) {
+	ts := batchServer(t, func(reqs []map[string]any) any {
+		if len(reqs) == 1 {
+			return map[string]any{"jsonrpc": version2, "id": nil, "error": map[string]any{"code": -32600, "message": "Invalid Request"}}
+		}
+		return []map[string]any{{"jsonrpc": version2, "id": reqs[0]["id"], "result": true}}
+	})
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+
+	_, err := client.CallBatch(context.Background(), []*Request{NewRequest("a", nil, 1)})
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32600 {
+		t.Errorf("whole batch error: got %v", err)
+	}
+
+	responses, err := client.CallBatch(context.Background(), []*Request{NewRequest("a", nil, 1), NewRequest("b", nil, 2)})
+	if !errors.Is(err, ErrMissingResponse) || responses[0] == nil || responses[1] != nil {
+		t.Errorf("missing response: got %v, %v", responses, err)
+	}
+}
@@ -0,0 +1,480 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReconnectMinWait = 500 * time.Millisecond
+	defaultReconnectMaxWait = 30 * time.Second
+	notificationQueueSize   = 64
+)
+
+var (
+	// ErrClosed is returned by calls on a closed connection.
+	ErrClosed = errors.New("jsonrpc: connection closed")
+	// ErrDisconnected is returned by calls when the connection is lost before they get a response.
+	ErrDisconnected = errors.New("jsonrpc: disconnected")
+)
+
+// NotificationHandler handles a notification received from the peer.
+type NotificationHandler func(params json.RawMessage)
+
+// messageConn sends and receives whole JSON-RPC messages.
+type messageConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(data []byte) error
+	Close() error
+}
+
+type dialFunc func(ctx context.Context) (messageConn, error)
+
+// serveFunc handles an incoming request or batch and returns the reply, nil if there is none.
+type serveFunc func(ctx context.Context, data []byte) []byte
+
+// message holds the members of any JSON-RPC message, to tell requests, notifications and responses apart.
+type message struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// Conn is a bidirectional JSON-RPC connection. Concurrent calls are multiplexed over it
+// and matched to their responses by ID. Notifications received from the peer are delivered
+// to the handlers registered with HandleNotification.
+//
+// Requests from the peer are served by a Server: the one of Server.ServeWebSocket, or the one set with Serve.
+//
+// A client Conn reconnects transparently when the connection is lost.
+// Conns of Server.ServeWebSocket and NewStreamConn live as long as their connection.
+type Conn struct {
+	dial    dialFunc
+	serve   serveFunc
+	timeout time.Duration
+	minWait time.Duration
+	maxWait time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	mc      messageConn
+	ready   chan struct{} // closed once mc is set
+	pending map[string]chan *Response
+	writeMu sync.Mutex
+
+	handlersMu    sync.RWMutex
+	handlers      map[string]NotificationHandler
+	notifications chan message
+
+	droppedNotifications atomic.Uint64
+}
+
+func newConn(dial dialFunc, timeout, minWait, maxWait time.Duration) *Conn {
+	c := allocConn(timeout)
+	c.dial = dial
+	c.minWait = minWait
+	c.maxWait = maxWait
+	go c.notify()
+	go c.connectLoop()
+	return c
+}
+
+// acceptConn returns a Conn for an accepted connection, whose requests are handled by serve.
+// Run it with serveConn.
+func acceptConn(mc messageConn, timeout time.Duration, serve serveFunc) *Conn {
+	c := allocConn(timeout)
+	c.serve = serve
+	c.setConn(mc)
+	go c.notify()
+	return c
+}
+
+func allocConn(timeout time.Duration) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		timeout:       timeout,
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		ready:         make(chan struct{}),
+		pending:       make(map[string]chan *Response),
+		handlers:      make(map[string]NotificationHandler),
+		notifications: make(chan message, notificationQueueSize),
+	}
+}
+
+// serveConn reads the accepted connection until it is closed.
+func (c *Conn) serveConn() {
+	defer close(c.done)
+
+	err := c.readLoop(c.mc)
+	c.dropConn(c.mc)
+	c.cancel()
+	slog.Debug("jsonrpc: connection closed", "err", err)
+}
+
+// connectLoop keeps the connection up until Close.
+func (c *Conn) connectLoop() {
+	defer close(c.done)
+
+	for attempt := 0; ; attempt++ {
+		mc, err := c.dial(c.ctx)
+		if err == nil {
+			attempt = 0
+			c.setConn(mc)
+			err = c.readLoop(mc)
+			c.dropConn(mc)
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		wait := min(c.minWait<<min(attempt, 16), c.maxWait)
+		slog.Error("jsonrpc: connection lost", "err", err, "retryIn", wait)
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Conn) setConn(mc messageConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mc = mc
+	close(c.ready)
+}
+
+// dropConn fails all pending calls, and makes new calls wait for the next connection.
+func (c *Conn) dropConn(mc messageConn) {
+	mc.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mc = nil
+	c.ready = make(chan struct{})
+	for key, ch := range c.pending {
+		close(ch)
+		delete(c.pending, key)
+	}
+}
+
+func (c *Conn) readLoop(mc messageConn) error {
+	for {
+		data, err := mc.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 && data[0] == '[' {
+			var batch []json.RawMessage
+			if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 || !isResponse(batch[0]) {
+				// a batch of requests is served as a whole, to reply with a batch
+				c.serveMessage(data)
+				continue
+			}
+			for _, raw := range batch {
+				c.dispatch(raw)
+			}
+			continue
+		}
+		c.dispatch(data)
+	}
+}
+
+// dispatch resolves responses, and delivers notifications to their handler.
+// Requests, and notifications without handler, go to the server.
+func (c *Conn) dispatch(raw json.RawMessage) {
+	var msg message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		c.serveMessage(raw)
+		return
+	}
+
+	switch {
+	case msg.Method != "" && msg.ID == nil:
+		c.handlersMu.RLock()
+		_, ok := c.handlers[msg.Method]
+		c.handlersMu.RUnlock()
+		if !ok {
+			c.serveMessage(raw)
+			return
+		}
+		// the read loop must not wait for slow handlers, responses would be delayed
+		select {
+		case c.notifications <- msg:
+		default:
+			c.droppedNotifications.Add(1)
+			slog.Warn("jsonrpc: notification queue full, dropped", "method", msg.Method)
+		}
+	case msg.Method != "":
+		c.serveMessage(raw)
+	default:
+		var resp Response
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			slog.Error("jsonrpc: invalid response", "err", err)
+			return
+		}
+		c.resolve(string(msg.ID), &resp)
+	}
+}
+
+// serveMessage serves a request, notification or batch from the peer in its own goroutine, and writes the reply.
+func (c *Conn) serveMessage(data []byte) {
+	if c.serve == nil {
+		slog.Warn("jsonrpc: unhandled message", "message", string(data))
+		return
+	}
+
+	go func() {
+		if reply := c.serve(withConn(c.ctx, c), data); reply != nil {
+			if err := c.write(c.ctx, reply); err != nil {
+				slog.Error("jsonrpc: failed to write response", "err", err)
+			}
+		}
+	}()
+}
+
+func (c *Conn) resolve(key string, resp *Response) {
+	c.mu.Lock()
+	ch, ok := c.pending[key]
+	delete(c.pending, key)
+	c.mu.Unlock()
+
+	if !ok {
+		slog.Warn("jsonrpc: response to unknown id", "id", key)
+		return
+	}
+	ch <- resp
+}
+
+// notify runs notification handlers one at a time, in the order notifications are received.
+func (c *Conn) notify() {
+	for {
+		select {
+		case msg := <-c.notifications:
+			c.handlersMu.RLock()
+			h, ok := c.handlers[msg.Method]
+			c.handlersMu.RUnlock()
+			if ok {
+				h(msg.Params)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// HandleNotification registers the handler of notifications for method. Handlers run one at a time,
+// so a slow handler delays the following notifications but not responses. Up to 64 notifications
+// are queued, the following ones are dropped until the handlers catch up, see DroppedNotifications.
+func (c *Conn) HandleNotification(method string, h NotificationHandler) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+
+	c.handlers[method] = h
+}
+
+// DroppedNotifications returns the number of notifications dropped because the handlers were behind.
+func (c *Conn) DroppedNotifications() uint64 {
+	return c.droppedNotifications.Load()
+}
+
+// Call sends request and waits for its response. If ctx has no deadline, the connection timeout applies.
+func (c *Conn) Call(ctx context.Context, request *Request) (*Response, error) {
+	if request.ID == nil {
+		return nil, fmt.Errorf("jsonrpc: call %v without id, use Notify", request.Method)
+	}
+	key, err := idKey(request.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	ch := make(chan *Response, 1)
+	if err := c.register(key, ch); err != nil {
+		return nil, err
+	}
+	defer c.unregister(key)
+
+	if err := c.send(ctx, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrClosed
+	}
+}
+
+// CallBatch sends requests as one batch and returns the responses in the order of requests,
+// with a nil response for notifications.
+func (c *Conn) CallBatch(ctx context.Context, requests []*Request) ([]*Response, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	keys := make([]string, len(requests))
+	channels := make([]chan *Response, len(requests))
+	for i, req := range requests {
+		if req.ID == nil {
+			continue
+		}
+		key, err := idKey(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		ch := make(chan *Response, 1)
+		if err := c.register(key, ch); err != nil {
+			return nil, err
+		}
+		defer c.unregister(key)
+		keys[i], channels[i] = key, ch
+	}
+
+	if err := c.send(ctx, requests); err != nil {
+		return nil, err
+	}
+
+	responses := make([]*Response, len(requests))
+	for i, ch := range channels {
+		if ch == nil {
+			continue
+		}
+		select {
+		case resp, ok := <-ch:
+			if !ok {
+				return responses, ErrDisconnected
+			}
+			responses[i] = resp
+		case <-ctx.Done():
+			return responses, fmt.Errorf("%w for id %s: %w", ErrMissingResponse, keys[i], ctx.Err())
+		case <-c.done:
+			return responses, ErrClosed
+		}
+	}
+	return responses, nil
+}
+
+// Notify sends a notification, which gets no response.
+func (c *Conn) Notify(ctx context.Context, method string, params any) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	return c.send(ctx, &Notification{Version: version2, Method: method, Params: omitNil(params)})
+}
+
+// Close closes the connection and fails the pending calls.
+func (c *Conn) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	mc := c.mc
+	c.mu.Unlock()
+	if mc != nil {
+		mc.Close()
+	}
+
+	<-c.done
+	return nil
+}
+
+func (c *Conn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline || c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func (c *Conn) register(key string, ch chan *Response) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pending[key]; ok {
+		return fmt.Errorf("jsonrpc: a call with id %s is already pending", key)
+	}
+	c.pending[key] = ch
+	return nil
+}
+
+func (c *Conn) unregister(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, key)
+}
+
+// send writes v once connected.
+func (c *Conn) send(ctx context.Context, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(ctx, data)
+}
+
+func (c *Conn) write(ctx context.Context, data []byte) error {
+	for {
+		c.mu.Lock()
+		mc, ready := c.mc, c.ready
+		c.mu.Unlock()
+
+		if mc != nil {
+			c.writeMu.Lock()
+			defer c.writeMu.Unlock()
+			if err := mc.WriteMessage(data); err != nil {
+				return fmt.Errorf("%w: %w", ErrDisconnected, err)
+			}
+			return nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return ErrClosed
+		}
+	}
+}
+
+type connKey struct{}
+
+func withConn(ctx context.Context, c *Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// ConnFromContext returns the connection a request was received on, in handlers of WebSocket requests.
+// Handlers can keep it to push notifications to that client.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(*Conn)
+	return c, ok
+}
+
+// isResponse reports whether raw is a response, that has no method.
+func isResponse(raw json.RawMessage) bool {
+	var msg message
+	return json.Unmarshal(raw, &msg) == nil && msg.Method == ""
+}
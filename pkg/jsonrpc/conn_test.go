@@ -0,0 +1,165 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsEchoServer replies to "echo" with its params, after a delay given as first param (ms),
+// sends a "tick" notification on "tick", and drops the connection on "drop".
+func wsEchoServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var connections atomic.Int32
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		connections.Add(1)
+		defer conn.Close()
+
+		var mu sync.Mutex
+		write := func(v any) {
+			mu.Lock()
+			defer mu.Unlock()
+			conn.WriteJSON(v)
+		}
+		for {
+			var req struct {
+				Method string            `json:"method"`
+				Params []json.RawMessage `json:"params"`
+				ID     json.RawMessage   `json:"id"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			switch req.Method {
+			case "echo":
+				go func() {
+					var delay int
+					json.Unmarshal(req.Params[0], &delay)
+					time.Sleep(time.Duration(delay) * time.Millisecond)
+					write(map[string]any{"jsonrpc": version2, "id": req.ID, "result": req.Params[1]})
+				}()
+			case "tick":
+				write(map[string]any{"jsonrpc": version2, "method": "tick", "params": req.Params})
+			case "drop":
+				return
+			}
+		}
+	}))
+	return ts, &connections
+}
+
+func TestClient_WebSocket(t *testing.T) {
+	ts, connections := wsEchoServer(t)
+	defer ts.Close()
+
+	client := NewClient("ws"+strings.TrimPrefix(ts.URL, "http"), Reconnect(10*time.Millisecond, 50*time.Millisecond))
+	defer client.Close()
+
+	ticks := make(chan string, 1)
+	client.HandleNotification("tick", func(params json.RawMessage) {
+		ticks <- string(params)
+	})
+
+	// concurrent calls, answered in reverse order
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Call(context.Background(), NewRequest("echo", []any{(5 - i) * 10, i}, i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var got int
+			if err := resp.GetAny(&got); err != nil || got != i {
+				t.Errorf("call %d got %d, %v", i, got, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := client.Notify(context.Background(), "tick", []any{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-ticks:
+		if got != `["a"]` {
+			t.Errorf("notification params = %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+	}
+
+	// a pending call fails on disconnect, and the client reconnects
+	pending := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), NewRequest("echo", []any{1000, 0}, "slow"))
+		pending <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	client.Notify(context.Background(), "drop", nil)
+	if err := <-pending; !errors.Is(err, ErrDisconnected) {
+		t.Errorf("pending call: got %v, want ErrDisconnected", err)
+	}
+
+	if _, err := client.Call(context.Background(), NewRequest("echo", []any{0, 1}, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if connections.Load() != 2 {
+		t.Errorf("connections = %d, want 2", connections.Load())
+	}
+}
+
+func TestClient_TCPTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// reply to the first request only
+		r := bufio.NewReader(conn)
+		line, _ := r.ReadBytes('\n')
+		var req Request
+		json.Unmarshal(line, &req)
+		json.NewEncoder(conn).Encode(map[string]any{"jsonrpc": version2, "id": req.ID, "result": "pong"})
+		r.ReadBytes('\n')
+		time.Sleep(time.Second)
+	}()
+
+	client := NewClient("tcp://"+l.Addr().String(), Timeout(50*time.Millisecond))
+	defer client.Close()
+
+	resp, err := client.Call(context.Background(), NewRequest("ping", nil, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := resp.GetString(); s != "pong" {
+		t.Errorf("got %v", resp.Result)
+	}
+
+	if _, err = client.Call(context.Background(), NewRequest("ping", nil, 2)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,126 @@
+package jsonrpc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/Lysander66/zephyr/pkg/znet"
+)
+
+// Invoker sends a request and returns its response.
+type Invoker func(ctx context.Context, request *Request) (*Response, error)
+
+// Interceptor intercepts the calls of a Client. It may change the request or the context,
+// and calls invoker to continue the call.
+type Interceptor func(ctx context.Context, request *Request, invoker Invoker) (*Response, error)
+
+// Interceptors sets the interceptors of calls, the first one being the outermost.
+// They apply to Call, not to CallBatch and Notify.
+func Interceptors(interceptors ...Interceptor) Option {
+	return func(o *Client) { o.interceptors = append(o.interceptors, interceptors...) }
+}
+
+// chain returns an invoker running the interceptors around invoker.
+func chain(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, request *Request) (*Response, error) {
+			return interceptor(ctx, request, next)
+		}
+	}
+	return invoker
+}
+
+type headerKey struct{}
+
+// WithHeader returns a context carrying HTTP headers for the calls made with it, added to
+// the headers set with the Header option. It applies to HTTP clients only.
+func WithHeader(ctx context.Context, header http.Header) context.Context {
+	if h, ok := ctx.Value(headerKey{}).(http.Header); ok {
+		merged := h.Clone()
+		for k, v := range header {
+			merged[k] = append(merged[k], v...)
+		}
+		header = merged
+	}
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// LoggingInterceptor logs calls with their duration, and failed calls at error level.
+func LoggingInterceptor(logger *slog.Logger) Interceptor {
+	return func(ctx context.Context, request *Request, invoker Invoker) (*Response, error) {
+		start := time.Now()
+		resp, err := invoker(ctx, request)
+
+		attrs := []any{"method", request.Method, "id", request.ID, "duration", time.Since(start)}
+		switch {
+		case err != nil:
+			logger.ErrorContext(ctx, "jsonrpc: call failed", append(attrs, "err", err)...)
+		case resp.Error != nil:
+			logger.WarnContext(ctx, "jsonrpc: call error", append(attrs, "err", resp.Error)...)
+		default:
+			logger.DebugContext(ctx, "jsonrpc: call", attrs...)
+		}
+		return resp, err
+	}
+}
+
+// RetryInterceptor retries failed calls with the znet backoff, e.g.
+//
+//	jsonrpc.RetryInterceptor(znet.Retries(3), znet.WaitTime(200*time.Millisecond))
+//
+// By default, calls are retried on errors such as ErrDisconnected, not on error responses.
+func RetryInterceptor(options ...znet.Option) Interceptor {
+	return func(ctx context.Context, request *Request, invoker Invoker) (*Response, error) {
+		var resp *Response
+		err := znet.BackoffWithContext(ctx, func() error {
+			var err error
+			resp, err = invoker(ctx, request)
+			return err
+		}, options...)
+		return resp, err
+	}
+}
+
+// HeaderInterceptor adds header to the calls of HTTP clients, e.g. to set an Authorization header.
+func HeaderInterceptor(header func(ctx context.Context) http.Header) Interceptor {
+	return func(ctx context.Context, request *Request, invoker Invoker) (*Response, error) {
+		return invoker(WithHeader(ctx, header(ctx)), request)
+	}
+}
+
+// PrependParam prepends v to the positional params of calls, e.g. aria2's secret token:
+//
+//	jsonrpc.PrependParam("token:" + secret)
+func PrependParam(v any) Interceptor {
+	return func(ctx context.Context, request *Request, invoker Invoker) (*Response, error) {
+		params, err := prependParam(v, request.Params)
+		if err != nil {
+			return nil, err
+		}
+		req := *request
+		req.Params = params
+		return invoker(ctx, &req)
+	}
+}
+
+func prependParam(v any, params any) ([]any, error) {
+	if params == nil {
+		return []any{v}, nil
+	}
+
+	rv := reflect.ValueOf(params)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("jsonrpc: cannot prepend a param to params of type %T", params)
+	}
+	prepended := make([]any, 0, rv.Len()+1)
+	prepended = append(prepended, v)
+	for i := range rv.Len() {
+		prepended = append(prepended, rv.Index(i).Interface())
+	}
+	return prepended, nil
+}
@@ -0,0 +1,81 @@
+package jsonrpc
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Lysander66/zephyr/pkg/znet"
+)
+
+func TestInterceptors(t *testing.T) {
+	s := NewServer()
+	s.RegisterFunc("aria2.getVersion", func(_ context.Context, params []string) ([]string, error) {
+		return params, nil
+	})
+
+	var failures atomic.Int32
+	failures.Store(2)
+	var gotHeader http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gotHeader = r.Header
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, request *Request, invoker Invoker) (*Response, error) {
+			order = append(order, name)
+			return invoker(ctx, request)
+		}
+	}
+
+	client := NewClient(ts.URL,
+		Header(http.Header{"X-Static": {"1"}}),
+		Interceptors(
+			trace("outer"),
+			LoggingInterceptor(slog.New(slog.NewTextHandler(io.Discard, nil))),
+			RetryInterceptor(znet.Retries(2), znet.WaitTime(time.Millisecond)),
+			HeaderInterceptor(func(context.Context) http.Header {
+				return http.Header{"Authorization": {"Bearer t0k3n"}}
+			}),
+			PrependParam("token:secret"),
+			trace("inner"),
+		),
+	)
+
+	ctx := WithHeader(context.Background(), http.Header{"X-Request-Id": {"abc"}})
+	got, err := Call[[]string, []string](ctx, client, "aria2.getVersion", []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "token:secret" || got[1] != "a" {
+		t.Errorf("params = %v", got)
+	}
+	// the inner interceptor ran once per attempt
+	if len(order) != 4 || order[0] != "outer" || order[3] != "inner" {
+		t.Errorf("order = %v", order)
+	}
+	for k, want := range map[string]string{"Authorization": "Bearer t0k3n", "X-Request-Id": "abc", "X-Static": "1"} {
+		if gotHeader.Get(k) != want {
+			t.Errorf("header %s = %q, want %q", k, gotHeader.Get(k), want)
+		}
+	}
+}
+
+func TestNextID(t *testing.T) {
+	a, b := NextID(), NextID()
+	if b <= a {
+		t.Errorf("NextID() = %d after %d", b, a)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,11 +21,12 @@ type RequestID interface {
 	~string | ~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
 }
 
+// Request is a JSON-RPC request. A Request without ID is a notification, the server does not reply to it.
 type Request struct {
 	Version string `json:"jsonrpc"`
 	Method  string `json:"method"`
 	Params  any    `json:"params,omitempty"`
-	ID      any    `json:"id"`
+	ID      any    `json:"id,omitempty"`
 }
 
 type Notification struct {
@@ -30,11 +35,12 @@ type Notification struct {
 	Params  any    `json:"params,omitempty"`
 }
 
+// Response is a JSON-RPC response. Result is kept raw, to be decoded with GetAny into the expected type.
 type Response struct {
-	Version string `json:"jsonrpc"`
-	Result  any    `json:"result"`
-	Error   *Error `json:"error,omitempty"`
-	ID      any    `json:"id"`
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      any             `json:"id"`
 }
 
 type Error struct {
@@ -48,31 +54,57 @@ func (e *Error) Error() string {
 }
 
 func (r *Response) GetString() (string, error) {
-	val, ok := r.Result.(string)
-	if !ok {
+	var val string
+	if err := json.Unmarshal(r.Result, &val); err != nil {
 		return "", fmt.Errorf("couldn't parse string from %s", r.Result)
 	}
 
 	return val, nil
 }
 
+// GetAny decodes the result into v.
 func (r *Response) GetAny(v any) error {
-	data, err := json.Marshal(r.Result)
-	if err != nil {
-		return err
+	if r.Result == nil {
+		return fmt.Errorf("jsonrpc: no result")
 	}
-
-	return json.Unmarshal(data, v)
+	return json.Unmarshal(r.Result, v)
 }
 
 func NewRequest[T RequestID](method string, params any, id T) *Request {
-	return &Request{Version: version2, Method: method, Params: params, ID: id}
+	return &Request{Version: version2, Method: method, Params: omitNil(params), ID: id}
+}
+
+// omitNil returns nil for a nil slice, map or pointer, so that the params member is omitted
+// rather than sent as null, which servers reject.
+func omitNil(params any) any {
+	v := reflect.ValueOf(params)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	return params
 }
 
+// Client is a JSON-RPC client. The transport is picked from the endpoint scheme:
+// HTTP POST for http:// and https://, a WebSocket for ws:// and wss://,
+// and newline-delimited JSON over a TCP connection for tcp://host:port.
+//
+// WebSocket and TCP clients keep one connection, multiplexing concurrent calls,
+// receiving server notifications and reconnecting when the connection is lost.
 type Client struct {
 	endpoint   string
 	httpClient *http.Client
 	timeout    time.Duration
+	header     http.Header
+	minWait    time.Duration
+	maxWait    time.Duration
+	conn       *Conn
+	xml        bool
+
+	interceptors []Interceptor
+	invoke       Invoker
 }
 
 type Option func(o *Client)
@@ -85,24 +117,147 @@ func Timeout(timeout time.Duration) Option {
 	return func(o *Client) { o.timeout = timeout }
 }
 
+// Header sets the HTTP headers of requests, and of the WebSocket handshake.
+func Header(header http.Header) Option {
+	return func(o *Client) { o.header = header }
+}
+
+// Reconnect sets the min and max wait between reconnection attempts of WebSocket and TCP clients.
+// The wait doubles after each failed attempt.
+func Reconnect(minWait, maxWait time.Duration) Option {
+	return func(o *Client) {
+		o.minWait = minWait
+		o.maxWait = maxWait
+	}
+}
+
 func NewClient(endpoint string, opts ...Option) *Client {
 	c := &Client{
 		endpoint:   endpoint,
 		httpClient: &http.Client{},
 		timeout:    30 * time.Second, // default 30 seconds
+		minWait:    defaultReconnectMinWait,
+		maxWait:    defaultReconnectMaxWait,
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+
+	switch {
+	case strings.HasPrefix(endpoint, "ws://"), strings.HasPrefix(endpoint, "wss://"):
+		c.conn = newConn(dialWebSocket(endpoint, c.header), c.timeout, c.minWait, c.maxWait)
+	case strings.HasPrefix(endpoint, "tcp://"):
+		c.conn = newConn(dialTCP(strings.TrimPrefix(endpoint, "tcp://")), c.timeout, c.minWait, c.maxWait)
+	}
+	c.invoke = chain(c.interceptors, c.call)
 	return c
 }
 
+// Caller sends a request and returns its response, as *Client and *Conn do.
+type Caller interface {
+	Call(ctx context.Context, request *Request) (*Response, error)
+}
+
+// Call calls method with params and decodes the result into R.
+// If the server responds with an error, it is returned as a *Error.
+//
+//	stat, err := jsonrpc.Call[[]any, GlobalStat](ctx, client, "aria2.getGlobalStat", nil)
+func Call[P, R any](ctx context.Context, c Caller, method string, params P) (R, error) {
+	var result R
+	resp, err := c.Call(ctx, NewRequest(method, params, NextID()))
+	if err != nil {
+		return result, err
+	}
+	if resp.Error != nil {
+		return result, resp.Error
+	}
+	if err := resp.GetAny(&result); err != nil {
+		return result, fmt.Errorf("failed to decode result for %v: %w", method, err)
+	}
+	return result, nil
+}
+
+// Call sends request through the interceptors and returns its response.
+// An error response is not an error, check Response.Error.
 func (c *Client) Call(ctx context.Context, request *Request) (*Response, error) {
-	var payload bytes.Buffer
-	if err := json.NewEncoder(&payload).Encode(request); err != nil {
+	return c.invoke(ctx, request)
+}
+
+func (c *Client) call(ctx context.Context, request *Request) (*Response, error) {
+	if c.conn != nil {
+		return c.conn.Call(ctx, request)
+	}
+	if c.xml {
+		return c.callXML(ctx, request)
+	}
+
+	body, err := c.post(ctx, request, request.Method)
+	if err != nil {
 		return nil, err
 	}
 
+	var rpcResponse *Response
+	err = json.Unmarshal(body, &rpcResponse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response for %v: %v", request.Method, err)
+	}
+
+	if rpcResponse == nil {
+		return nil, fmt.Errorf("empty response for %v", request.Method)
+	}
+
+	return rpcResponse, nil
+}
+
+// Notify sends a notification, which gets no response.
+func (c *Client) Notify(ctx context.Context, method string, params any) error {
+	if c.conn != nil {
+		return c.conn.Notify(ctx, method, params)
+	}
+	if c.xml {
+		// XML-RPC has no notifications, the result is ignored
+		_, err := c.callXML(ctx, &Request{Method: method, Params: params})
+		return err
+	}
+
+	_, err := c.post(ctx, &Notification{Version: version2, Method: method, Params: omitNil(params)}, method)
+	return err
+}
+
+// HandleNotification registers the handler of server notifications for method.
+// Only WebSocket and TCP clients receive notifications.
+func (c *Client) HandleNotification(method string, h NotificationHandler) {
+	if c.conn != nil {
+		c.conn.HandleNotification(method, h)
+	}
+}
+
+// Close closes the connection of WebSocket and TCP clients.
+func (c *Client) Close() error {
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+var lastID atomic.Int64
+
+// NextID returns a request ID, unique in the process.
+func NextID() int64 {
+	return lastID.Add(1)
+}
+
+// post sends payload as JSON and returns the response body. name identifies the call in errors.
+func (c *Client) post(ctx context.Context, payload any, name string) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, body, "application/json", name)
+}
+
+// do posts body and returns the response body.
+func (c *Client) do(ctx context.Context, body []byte, contentType, name string) ([]byte, error) {
 	// If the passed ctx has no timeout, use the default timeout
 	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
 		var cancel context.CancelFunc
@@ -111,11 +266,19 @@ func (c *Client) Call(ctx context.Context, request *Request) (*Response, error)
 	}
 
 	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, &payload)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
+	if c.header != nil {
+		httpReq.Header = c.header.Clone()
+	}
+	if header, ok := ctx.Value(headerKey{}).(http.Header); ok {
+		for k, v := range header {
+			httpReq.Header[k] = append(httpReq.Header[k], v...)
+		}
+	}
+	httpReq.Header.Set("Content-Type", contentType)
 
 	httpResponse, err := c.httpClient.Do(httpReq)
 	if err != nil {
@@ -125,18 +288,8 @@ func (c *Client) Call(ctx context.Context, request *Request) (*Response, error)
 
 	// Check HTTP status code
 	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
-		return nil, fmt.Errorf("HTTP %v for method %v", httpResponse.StatusCode, request.Method)
-	}
-
-	var rpcResponse *Response
-	err = json.NewDecoder(httpResponse.Body).Decode(&rpcResponse)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response for %v: %v", request.Method, err)
+		return nil, fmt.Errorf("HTTP %v for method %v", httpResponse.StatusCode, name)
 	}
 
-	if rpcResponse == nil {
-		return nil, fmt.Errorf("empty response for %v", request.Method)
-	}
-
-	return rpcResponse, nil
+	return io.ReadAll(httpResponse.Body)
 }
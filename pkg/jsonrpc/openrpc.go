@@ -0,0 +1,246 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenRPC	https://spec.open-rpc.org
+const (
+	openRPCVersion = "1.3.2"
+	methodDiscover = "rpc.discover"
+)
+
+// OpenRPC is an OpenRPC document, describing the methods of a server.
+type OpenRPC struct {
+	OpenRPC    string      `json:"openrpc"`
+	Info       Info        `json:"info"`
+	Methods    []Method    `json:"methods"`
+	Components *Components `json:"components,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Method struct {
+	Name    string               `json:"name"`
+	Summary string               `json:"summary,omitempty"`
+	Params  []*ContentDescriptor `json:"params"`
+	Result  *ContentDescriptor   `json:"result,omitempty"`
+	// ParamStructure is "by-name", "by-position" or "either"
+	ParamStructure string `json:"paramStructure,omitempty"`
+	// ParamsValue is an extension, marking a single param that describes the whole params value,
+	// as for handlers of []T params that take any number of positional params
+	ParamsValue bool `json:"x-params-value,omitempty"`
+}
+
+type ContentDescriptor struct {
+	Name     string  `json:"name"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// Schema is the subset of JSON Schema reflected from Go types.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+const componentsSchemas = "#/components/schemas/"
+
+// ServerInfo sets the info of the OpenRPC document.
+func ServerInfo(info Info) ServerOption {
+	return func(s *Server) { s.info = info }
+}
+
+// OpenRPC returns the OpenRPC document of the registered methods, as served by rpc.discover.
+//
+// Struct params are described by name, one param per field. Other params, such as []T,
+// are described by a single param named "params" marked with the x-params-value extension.
+func (s *Server) OpenRPC() *OpenRPC {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r := &schemaReflector{schemas: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+	doc := &OpenRPC{OpenRPC: openRPCVersion, Info: s.info, Methods: []Method{}}
+	for _, name := range slices.Sorted(maps.Keys(s.methods)) {
+		m := s.methods[name]
+		doc.Methods = append(doc.Methods, r.method(name, m))
+	}
+	if len(r.schemas) > 0 {
+		doc.Components = &Components{Schemas: r.schemas}
+	}
+	return doc
+}
+
+// schemaReflector reflects JSON Schemas from Go types, named struct types going to the components.
+type schemaReflector struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string // component names of the reflected types
+}
+
+func (r *schemaReflector) method(name string, m method) Method {
+	desc := Method{Name: name, Params: []*ContentDescriptor{}}
+	if m.resultType != nil {
+		desc.Result = &ContentDescriptor{Name: "result", Schema: r.schema(m.resultType)}
+	} else {
+		desc.Result = &ContentDescriptor{Name: "result", Schema: &Schema{}}
+	}
+
+	switch t := m.paramsType; {
+	case t == nil:
+		desc.ParamStructure = "either"
+	case indirect(t).Kind() == reflect.Struct && t != reflect.TypeFor[time.Time]():
+		desc.ParamStructure = "by-name"
+		for _, f := range jsonFields(indirect(t)) {
+			desc.Params = append(desc.Params, &ContentDescriptor{
+				Name:     f.name,
+				Required: f.required,
+				Schema:   r.schema(f.typ),
+			})
+		}
+	case indirect(t).Kind() == reflect.Map:
+		desc.ParamStructure = "by-name"
+		desc.ParamsValue = true
+		desc.Params = append(desc.Params, &ContentDescriptor{Name: "params", Required: true, Schema: r.schema(t)})
+	default:
+		desc.ParamStructure = "by-position"
+		desc.ParamsValue = true
+		desc.Params = append(desc.Params, &ContentDescriptor{Name: "params", Required: true, Schema: r.schema(t)})
+	}
+	return desc
+}
+
+var rawMessageType = reflect.TypeFor[json.RawMessage]()
+
+func (r *schemaReflector) schema(t reflect.Type) *Schema {
+	t = indirect(t)
+	switch t {
+	case rawMessageType:
+		return &Schema{}
+	case reflect.TypeFor[time.Time]():
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoded as base64 by encoding/json
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: r.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.structSchema(t)
+		}
+		name, ok := r.names[t]
+		if !ok {
+			name = r.componentName(t)
+			r.names[t] = name
+			// registered before reflecting the fields, for recursive types
+			r.schemas[name] = &Schema{}
+			*r.schemas[name] = *r.structSchema(t)
+		}
+		return &Schema{Ref: componentsSchemas + name}
+	}
+	// interfaces, and types that cannot be encoded
+	return &Schema{}
+}
+
+// componentName returns the name of a struct type in the components: its name, qualified by its package,
+// e.g. "b.Item", if another type has the same name.
+func (r *schemaReflector) componentName(t reflect.Type) string {
+	qualified := strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + t.Name()
+	for _, name := range []string{t.Name(), path.Base(t.PkgPath()) + "." + t.Name(), qualified} {
+		if _, ok := r.schemas[name]; !ok {
+			return name
+		}
+	}
+	// types declared in functions of the same package
+	for i := 2; ; i++ {
+		if name := qualified + strconv.Itoa(i); r.schemas[name] == nil {
+			return name
+		}
+	}
+}
+
+func (r *schemaReflector) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for _, f := range jsonFields(t) {
+		s.Properties[f.name] = r.schema(f.typ)
+		if f.required {
+			s.Required = append(s.Required, f.name)
+		}
+	}
+	return s
+}
+
+type jsonField struct {
+	name     string
+	typ      reflect.Type
+	required bool
+}
+
+// jsonFields returns the fields of struct type t as encoding/json encodes them.
+// Fields with omitempty are not required.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" && indirect(f.Type).Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(indirect(f.Type))...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitempty := slices.Contains(strings.Split(opts, ","), "omitempty")
+		fields = append(fields, jsonField{name: name, typ: f.Type, required: !omitempty})
+	}
+	return fields
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
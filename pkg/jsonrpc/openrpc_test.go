@@ -0,0 +1,110 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type node struct {
+	Name     string    `json:"name"`
+	Children []*node   `json:"children,omitempty"`
+	Created  time.Time `json:"created"`
+	internal int
+}
+
+func TestServer_OpenRPC(t *testing.T) {
+	s := NewServer(ServerInfo(Info{Title: "arith", Version: "1.2.0"}))
+	s.RegisterService("arith", arith{})
+	s.RegisterFunc("tree", func(_ context.Context, p struct {
+		Root  node              `json:"root"`
+		Depth int               `json:"depth,omitempty"`
+		Tags  map[string]string `json:"tags"`
+	}) (*node, error) {
+		return &p.Root, nil
+	})
+	s.Register("raw", func(context.Context, json.RawMessage) (any, error) { return nil, nil })
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	doc, err := Call[any, *OpenRPC](context.Background(), NewClient(ts.URL), methodDiscover, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenRPC != openRPCVersion || doc.Info.Title != "arith" {
+		t.Errorf("openrpc = %s, info = %+v", doc.OpenRPC, doc.Info)
+	}
+
+	methods := make(map[string]Method)
+	for _, m := range doc.Methods {
+		methods[m.Name] = m
+	}
+	for _, name := range []string{"arith.sum", "arith.subtract", "arith.fail", "tree", "raw", methodDiscover} {
+		if _, ok := methods[name]; !ok {
+			t.Errorf("method %s is missing", name)
+		}
+	}
+
+	sum := methods["arith.sum"]
+	if sum.ParamStructure != "by-position" || !sum.ParamsValue || sum.Params[0].Schema.Type != "array" ||
+		sum.Params[0].Schema.Items.Type != "integer" || sum.Result.Schema.Type != "integer" {
+		t.Errorf("arith.sum = %s", mustJSON(sum))
+	}
+
+	subtract := methods["arith.subtract"]
+	if subtract.ParamStructure != "by-name" || len(subtract.Params) != 2 || subtract.Params[0].Name != "minuend" || !subtract.Params[0].Required {
+		t.Errorf("arith.subtract = %s", mustJSON(subtract))
+	}
+
+	tree := methods["tree"]
+	if len(tree.Params) != 3 || tree.Params[1].Required || tree.Params[2].Schema.AdditionalProperties.Type != "string" ||
+		tree.Result.Schema.Ref != "#/components/schemas/node" {
+		t.Errorf("tree = %s", mustJSON(tree))
+	}
+	nodeSchema := doc.Components.Schemas["node"]
+	if nodeSchema == nil || len(nodeSchema.Properties) != 3 || nodeSchema.Properties["children"].Items.Ref != "#/components/schemas/node" ||
+		nodeSchema.Properties["created"].Format != "date-time" || len(nodeSchema.Required) != 2 {
+		t.Errorf("node = %s", mustJSON(nodeSchema))
+	}
+
+	if raw := methods["raw"]; raw.ParamStructure != "either" || len(raw.Params) != 0 {
+		t.Errorf("raw = %s", mustJSON(raw))
+	}
+}
+
+// IPNet has the name of net.IPNet
+type IPNet struct {
+	Name string `json:"name"`
+}
+
+func TestServer_OpenRPCSameTypeName(t *testing.T) {
+	s := NewServer()
+	s.RegisterFunc("route", func(_ context.Context, p struct {
+		Local IPNet     `json:"local"`
+		Net   net.IPNet `json:"net"`
+	}) (*IPNet, error) {
+		return nil, nil
+	})
+
+	doc := s.OpenRPC()
+	route := doc.Methods[0]
+	if route.Params[0].Schema.Ref != "#/components/schemas/IPNet" || route.Params[1].Schema.Ref != "#/components/schemas/net.IPNet" ||
+		route.Result.Schema.Ref != "#/components/schemas/IPNet" {
+		t.Errorf("route = %s", mustJSON(route))
+	}
+	if local := doc.Components.Schemas["IPNet"]; local == nil || local.Properties["name"] == nil {
+		t.Errorf("IPNet = %s", mustJSON(local))
+	}
+	if ipNet := doc.Components.Schemas["net.IPNet"]; ipNet == nil || ipNet.Properties["IP"] == nil {
+		t.Errorf("net.IPNet = %s", mustJSON(ipNet))
+	}
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,379 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Error codes defined by the specification.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+const maxRequestSize = 10 << 20
+
+// Handler handles a request. params is the raw params member, nil if absent.
+//
+// A returned *Error is sent as is, any other error is sent as an internal error.
+type Handler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// Server is a JSON-RPC 2.0 server. It serves HTTP POST requests with ServeHTTP,
+// and WebSocket connections with ServeWebSocket, on which it can push notifications.
+type Server struct {
+	mu       sync.RWMutex
+	methods  map[string]method
+	info     Info
+	upgrader websocket.Upgrader
+
+	connsMu sync.Mutex
+	conns   map[*Conn]struct{}
+}
+
+type ServerOption func(s *Server)
+
+// CheckOrigin sets the function that accepts WebSocket handshakes by origin.
+// By default, cross-origin handshakes are rejected.
+func CheckOrigin(fn func(r *http.Request) bool) ServerOption {
+	return func(s *Server) { s.upgrader.CheckOrigin = fn }
+}
+
+// method is a registered method, with the Go types of its params and result if known.
+type method struct {
+	handler    Handler
+	paramsType reflect.Type
+	resultType reflect.Type
+}
+
+// NewServer returns a Server, that serves its OpenRPC document as rpc.discover.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		methods: make(map[string]method),
+		info:    Info{Title: "JSON-RPC API", Version: "1.0.0"},
+		conns:   make(map[*Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.register(methodDiscover, method{
+		handler: func(context.Context, json.RawMessage) (any, error) {
+			return s.OpenRPC(), nil
+		},
+		resultType: reflect.TypeFor[*OpenRPC](),
+	})
+	return s
+}
+
+// Register sets the handler of method. Its params and result are described as any JSON value in the OpenRPC document.
+func (s *Server) Register(name string, h Handler) {
+	s.register(name, method{handler: h})
+}
+
+func (s *Server) register(name string, m method) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.methods[name] = m
+}
+
+var (
+	contextType = reflect.TypeFor[context.Context]()
+	errorType   = reflect.TypeFor[error]()
+)
+
+// RegisterFunc registers fn, of type func(context.Context, P) (R, error), as the handler of method.
+// Params are decoded into P with encoding/json, a decoding failure is reported as invalid params.
+func (s *Server) RegisterFunc(name string, fn any) error {
+	m, err := funcMethod(reflect.ValueOf(fn))
+	if err != nil {
+		return fmt.Errorf("jsonrpc: register %s: %w", name, err)
+	}
+	s.register(name, m)
+	return nil
+}
+
+// RegisterService registers the exported methods of rcvr that are of type func(context.Context, P) (R, error),
+// as name.method with the first letter of the method lowercased, e.g. Queue.AddUri as "queue.addUri" for name "queue".
+func (s *Server) RegisterService(name string, rcvr any) error {
+	v := reflect.ValueOf(rcvr)
+	t := v.Type()
+
+	var registered int
+	for i := range t.NumMethod() {
+		m, err := funcMethod(v.Method(i))
+		if err != nil {
+			continue
+		}
+		s.register(name+"."+lowerFirst(t.Method(i).Name), m)
+		registered++
+	}
+	if registered == 0 {
+		return fmt.Errorf("jsonrpc: register %s: %s has no suitable method", name, t)
+	}
+	return nil
+}
+
+func funcMethod(fn reflect.Value) (method, error) {
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return method{}, fmt.Errorf("%s is not a func", t)
+	}
+	if t.NumIn() != 2 || t.In(0) != contextType || t.NumOut() != 2 || t.Out(1) != errorType {
+		return method{}, fmt.Errorf("%s is not func(context.Context, P) (R, error)", t)
+	}
+
+	paramsType := t.In(1)
+	h := func(ctx context.Context, params json.RawMessage) (any, error) {
+		p := reflect.New(paramsType)
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, p.Interface()); err != nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: "Invalid params", Data: err.Error()}
+			}
+		}
+		out := fn.Call([]reflect.Value{reflect.ValueOf(ctx), p.Elem()})
+		if err, _ := out[1].Interface().(error); err != nil {
+			return nil, err
+		}
+		return out[0].Interface(), nil
+	}
+	return method{handler: h, paramsType: paramsType, resultType: t.Out(0)}, nil
+}
+
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
+
+// ServeHTTP serves a request or a batch sent as the body of a POST request.
+// Notifications get a 204 No Content response.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	reply := s.handle(r.Context(), data)
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+}
+
+// ServeWebSocket upgrades the request to a WebSocket, and serves the requests received on it
+// until the connection is closed. Handlers get the connection with ConnFromContext.
+func (s *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has replied with an error
+		return
+	}
+
+	c := acceptConn(&wsConn{conn: ws}, 0, s.handle)
+	s.connsMu.Lock()
+	s.conns[c] = struct{}{}
+	s.connsMu.Unlock()
+
+	c.serveConn()
+
+	s.connsMu.Lock()
+	delete(s.conns, c)
+	s.connsMu.Unlock()
+}
+
+// Notify sends a notification to all the connected WebSocket clients.
+func (s *Server) Notify(ctx context.Context, method string, params any) error {
+	s.connsMu.Lock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connsMu.Unlock()
+
+	var errs []error
+	for _, c := range conns {
+		if err := c.Notify(ctx, method, params); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serverRequest holds the members of a request as received, to validate them.
+type serverRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  *string         `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// serverResponse has either Result or Error, as the specification requires.
+type serverResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var nullID = json.RawMessage("null")
+
+// handle serves a request or a batch, and returns the encoded reply, nil if there is none.
+func (s *Server) handle(ctx context.Context, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		return encodeReply(errorResponse(nullID, CodeParseError, "Parse error"))
+	}
+
+	if data[0] != '[' {
+		resp := s.handleOne(ctx, data)
+		if resp == nil {
+			return nil
+		}
+		return encodeReply(resp)
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(data, &batch)
+	if len(batch) == 0 {
+		return encodeReply(errorResponse(nullID, CodeInvalidRequest, "Invalid Request"))
+	}
+
+	responses := make([]*serverResponse, len(batch))
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = s.handleOne(ctx, raw)
+		}()
+	}
+	wg.Wait()
+
+	var replies []*serverResponse
+	for _, resp := range responses {
+		if resp != nil {
+			replies = append(replies, resp)
+		}
+	}
+	if len(replies) == 0 {
+		// a batch of notifications
+		return nil
+	}
+	return encodeReply(replies)
+}
+
+// handleOne serves a request, and returns its response, nil for a notification.
+func (s *Server) handleOne(ctx context.Context, raw json.RawMessage) *serverResponse {
+	var req serverRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errorResponse(nullID, CodeInvalidRequest, "Invalid Request")
+	}
+	if !validID(req.ID) {
+		return errorResponse(nullID, CodeInvalidRequest, "Invalid Request")
+	}
+	if string(req.Params) == "null" {
+		req.Params = nil
+	}
+	id := req.ID
+	if req.Version != version2 || req.Method == nil || *req.Method == "" || !validParams(req.Params) {
+		if id == nil {
+			id = nullID
+		}
+		return errorResponse(id, CodeInvalidRequest, "Invalid Request")
+	}
+
+	// id is absent from notifications, and "null" in requests with a null id
+	notification := id == nil
+	result, err := s.call(ctx, *req.Method, req.Params)
+	if notification {
+		if err != nil {
+			slog.Warn("jsonrpc: notification failed", "method", *req.Method, "err", err)
+		}
+		return nil
+	}
+
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		return &serverResponse{Version: version2, Error: rpcErr, ID: id}
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(id, CodeInternalError, fmt.Sprintf("failed to encode result: %v", err))
+	}
+	return &serverResponse{Version: version2, Result: b, ID: id}
+}
+
+// call runs the handler of method, turning panics into internal errors.
+func (s *Server) call(ctx context.Context, method string, params json.RawMessage) (result any, err error) {
+	s.mu.RLock()
+	m, ok := s.methods[method]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "Method not found"}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("jsonrpc: handler panic", "method", method, "panic", r)
+			err = &Error{Code: CodeInternalError, Message: "Internal error"}
+		}
+	}()
+	return m.handler(ctx, params)
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *serverResponse {
+	return &serverResponse{Version: version2, Error: &Error{Code: code, Message: message}, ID: id}
+}
+
+func encodeReply(v any) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("jsonrpc: failed to encode reply", "err", err)
+		return nil
+	}
+	return b
+}
+
+// validID reports whether id is absent, null, a string or a number.
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case 'n', '"', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// validParams reports whether params is absent, an array or an object.
+func validParams(params json.RawMessage) bool {
+	return params == nil || params[0] == '[' || params[0] == '{'
+}
@@ -0,0 +1,273 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type subtractParams struct {
+	Minuend    int `json:"minuend"`
+	Subtrahend int `json:"subtrahend"`
+}
+
+type arith struct{}
+
+func (arith) Sum(_ context.Context, params []int) (int, error) {
+	var sum int
+	for _, n := range params {
+		sum += n
+	}
+	return sum, nil
+}
+
+func (arith) Subtract(_ context.Context, p subtractParams) (int, error) {
+	return p.Minuend - p.Subtrahend, nil
+}
+
+func (arith) Fail(context.Context, struct{}) (any, error) {
+	return nil, &Error{Code: -32000, Message: "failed"}
+}
+
+func (arith) Help() string { return "not registered" }
+
+func newTestServer(t *testing.T) *Server {
+	s := NewServer()
+	if err := s.RegisterService("arith", arith{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Register("notify_hello", func(context.Context, json.RawMessage) (any, error) { return nil, nil })
+	s.Register("panic", func(context.Context, json.RawMessage) (any, error) { panic("boom") })
+	if err := s.RegisterFunc("echo", func(_ context.Context, s string) (string, error) { return s, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFunc("bad", func(string) string { return "" }); err == nil {
+		t.Error("RegisterFunc accepted func(string) string")
+	}
+	return s
+}
+
+// Examples of the specification, section 7.
+func TestServer_ServeHTTP(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t))
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		req  string
+		want string
+	}{
+		{"positional params", `{"jsonrpc": "2.0", "method": "arith.sum", "params": [1, 2, 4], "id": 1}`,
+			`{"jsonrpc":"2.0","result":7,"id":1}`},
+		{"named params", `{"jsonrpc": "2.0", "method": "arith.subtract", "params": {"subtrahend": 23, "minuend": 42}, "id": "3"}`,
+			`{"jsonrpc":"2.0","result":19,"id":"3"}`},
+		{"params not structured", `{"jsonrpc": "2.0", "method": "echo", "params": "x", "id": null}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{"null id", `{"jsonrpc": "2.0", "method": "arith.sum", "params": [1], "id": null}`,
+			`{"jsonrpc":"2.0","result":1,"id":null}`},
+		{"notification", `{"jsonrpc": "2.0", "method": "arith.sum", "params": [1, 2]}`, ``},
+		{"method not found", `{"jsonrpc": "2.0", "method": "foobar", "id": "1"}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"1"}`},
+		{"invalid params", `{"jsonrpc": "2.0", "method": "arith.subtract", "params": [42, 23], "id": 2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"json: cannot unmarshal array into Go value of type jsonrpc.subtractParams"},"id":2}`},
+		{"handler error", `{"jsonrpc": "2.0", "method": "arith.fail", "id": 4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"failed"},"id":4}`},
+		{"panic", `{"jsonrpc": "2.0", "method": "panic", "id": 5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"Internal error"},"id":5}`},
+		{"parse error", `{"jsonrpc": "2.0", "method": "foobar, "params": "bar", "baz]`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{"invalid request", `{"jsonrpc": "2.0", "method": 1, "params": "bar"}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{"invalid batch", `[{"jsonrpc": "2.0", "method": "sum", "params": [1,2,4], "id": "1"},{"jsonrpc": "2.0", "method"]`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{"empty batch", `[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{"batch of invalid requests", `[1,2]`,
+			`[{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`},
+		{"batch", `[
+			{"jsonrpc": "2.0", "method": "arith.sum", "params": [1,2,4], "id": "1"},
+			{"jsonrpc": "2.0", "method": "notify_hello", "params": [7]},
+			{"jsonrpc": "2.0", "method": "arith.subtract", "params": {"minuend": 42, "subtrahend": 23}, "id": "2"},
+			{"foo": "boo"},
+			{"jsonrpc": "2.0", "method": "foo.get", "params": {"name": "myself"}, "id": "5"}
+		]`,
+			`[{"jsonrpc":"2.0","result":7,"id":"1"},{"jsonrpc":"2.0","result":19,"id":"2"},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null},` +
+				`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"5"}]`},
+		{"batch of notifications", `[{"jsonrpc": "2.0", "method": "notify_hello", "params": [1]},{"jsonrpc": "2.0", "method": "notify_hello", "params": [7]}]`, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL, "application/json", strings.NewReader(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if tt.want == "" {
+				if resp.StatusCode != http.StatusNoContent || len(body) != 0 {
+					t.Errorf("got %d %s, want no content", resp.StatusCode, body)
+				}
+				return
+			}
+			if string(body) != tt.want {
+				t.Errorf("got  %s\nwant %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Client(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t))
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	resp, err := client.Call(context.Background(), NewRequest("echo", []string{"hi"}, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Code != CodeInvalidParams {
+		t.Errorf("got %+v", resp.Error)
+	}
+
+	resp, err = client.Call(context.Background(), NewRequest("arith.subtract", subtractParams{Minuend: 5, Subtrahend: 3}, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := strconv.Atoi(string(resp.Result)); n != 2 {
+		t.Errorf("got %v", resp.Result)
+	}
+
+	responses, err := client.CallBatch(context.Background(), []*Request{
+		NewRequest("arith.sum", []int{1, 2}, 1),
+		{Version: version2, Method: "notify_hello"},
+		NewRequest("nope", nil, 2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	if err := responses[0].GetAny(&sum); err != nil || sum != 3 {
+		t.Errorf("sum = %d, %v", sum, err)
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != CodeMethodNotFound {
+		t.Errorf("got %+v", responses[2])
+	}
+
+	if err := client.Notify(context.Background(), "notify_hello", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t))
+	defer ts.Close()
+	client := NewClient(ts.URL)
+
+	diff, err := Call[subtractParams, int](context.Background(), client, "arith.subtract", subtractParams{Minuend: 42, Subtrahend: 23})
+	if err != nil || diff != 19 {
+		t.Errorf("diff = %d, %v", diff, err)
+	}
+
+	_, err = Call[[]int, int](context.Background(), client, "arith.fail", nil)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32000 {
+		t.Errorf("err = %v, want *Error", err)
+	}
+
+	_, err = Call[[]int, string](context.Background(), client, "arith.sum", []int{1})
+	if err == nil || errors.As(err, &rpcErr) {
+		t.Errorf("err = %v, want a decoding error", err)
+	}
+}
+
+func TestNewRequest_NilParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params any
+		want   string
+	}{
+		{"nil", nil, `{"jsonrpc":"2.0","method":"m","id":1}`},
+		{"nil slice", []any(nil), `{"jsonrpc":"2.0","method":"m","id":1}`},
+		{"nil map", map[string]any(nil), `{"jsonrpc":"2.0","method":"m","id":1}`},
+		{"nil pointer", (*subtractParams)(nil), `{"jsonrpc":"2.0","method":"m","id":1}`},
+		{"empty slice", []any{}, `{"jsonrpc":"2.0","method":"m","params":[],"id":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(NewRequest("m", tt.params, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ServeWebSocket(t *testing.T) {
+	s := newTestServer(t)
+	subscribed := make(chan *Conn, 1)
+	s.Register("subscribe", func(ctx context.Context, _ json.RawMessage) (any, error) {
+		c, ok := ConnFromContext(ctx)
+		if !ok {
+			return nil, errors.New("no connection")
+		}
+		subscribed <- c
+		return true, nil
+	})
+	ts := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	defer ts.Close()
+
+	client := NewClient("ws" + strings.TrimPrefix(ts.URL, "http"))
+	defer client.Close()
+
+	events := make(chan string, 2)
+	client.HandleNotification("event", func(params json.RawMessage) {
+		events <- string(params)
+	})
+
+	resp, err := client.Call(context.Background(), NewRequest("subscribe", nil, 1))
+	if err != nil || resp.Error != nil {
+		t.Fatal(err, resp.Error)
+	}
+	conn := <-subscribed
+
+	if err := conn.Notify(context.Background(), "event", []string{"one"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Notify(context.Background(), "event", []string{"all"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`["one"]`, `["all"]`} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no notification %s", want)
+		}
+	}
+
+	responses, err := client.CallBatch(context.Background(), []*Request{
+		NewRequest("arith.sum", []int{1, 2}, 2),
+		NewRequest("arith.subtract", subtractParams{Minuend: 3, Subtrahend: 1}, 3),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff int
+	if err := responses[1].GetAny(&diff); err != nil || diff != 2 {
+		t.Errorf("diff = %d, %v", diff, err)
+	}
+}
@@ -0,0 +1,157 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// Framing delimits JSON-RPC messages on a byte stream.
+type Framing int
+
+const (
+	// FramingNDJSON is newline-delimited JSON, one message per line.
+	FramingNDJSON Framing = iota
+	// FramingContentLength prefixes messages with headers, as the Language Server Protocol:
+	//
+	//	Content-Length: 52\r\n
+	//	\r\n
+	//	{"jsonrpc":"2.0","method":"initialized","params":{}}
+	FramingContentLength
+)
+
+type ConnOption func(c *Conn)
+
+// Serve serves the requests and notifications from the peer with s.
+// Notifications with a handler registered by HandleNotification are not served.
+func Serve(s *Server) ConnOption {
+	return func(c *Conn) { c.serve = s.handle }
+}
+
+// CallTimeout sets the timeout of calls whose context has no deadline, 30 seconds by default.
+func CallTimeout(timeout time.Duration) ConnOption {
+	return func(c *Conn) { c.timeout = timeout }
+}
+
+// NewStreamConn returns a Conn over rwc, such as the stdin/stdout of a process, with full-duplex
+// calls and notifications. The Conn closes rwc when closed, or when rwc is at EOF.
+//
+//	rwc, _ := zexec.StartPipe("helper", "--stdio")
+//	conn := jsonrpc.NewStreamConn(rwc, jsonrpc.FramingContentLength, jsonrpc.Serve(server))
+func NewStreamConn(rwc io.ReadWriteCloser, framing Framing, opts ...ConnOption) *Conn {
+	var mc messageConn
+	switch framing {
+	case FramingContentLength:
+		mc = newHeaderConn(rwc)
+	default:
+		mc = newNDJSONConn(rwc)
+	}
+
+	c := acceptConn(mc, 30*time.Second, nil)
+	for _, opt := range opts {
+		opt(c)
+	}
+	go c.serveConn()
+	return c
+}
+
+// setWriteDeadline sets the write deadline of streams that support it, such as network connections and pipes.
+func setWriteDeadline(w io.Writer) {
+	if d, ok := w.(interface{ SetWriteDeadline(t time.Time) error }); ok {
+		d.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+}
+
+// ndjsonConn carries newline-delimited JSON messages over a stream.
+type ndjsonConn struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+	w   *bufio.Writer
+}
+
+func newNDJSONConn(rwc io.ReadWriteCloser) *ndjsonConn {
+	return &ndjsonConn{rwc: rwc, r: bufio.NewReader(rwc), w: bufio.NewWriter(rwc)}
+}
+
+func (c *ndjsonConn) ReadMessage() ([]byte, error) {
+	for {
+		line, err := c.readLine()
+		if len(bytes.TrimSpace(line)) > 0 {
+			// the last message may lack its newline
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readLine reads up to and including the next newline, and fails once the line exceeds maxRequestSize.
+func (c *ndjsonConn) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := c.r.ReadSlice('\n')
+		if len(line)+len(frag) > maxRequestSize {
+			return nil, fmt.Errorf("jsonrpc: message exceeds %d bytes", maxRequestSize)
+		}
+		line = append(line, frag...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+func (c *ndjsonConn) WriteMessage(data []byte) error {
+	setWriteDeadline(c.rwc)
+	c.w.Write(data)
+	c.w.WriteByte('\n')
+	return c.w.Flush()
+}
+
+func (c *ndjsonConn) Close() error {
+	return c.rwc.Close()
+}
+
+// headerConn carries messages prefixed with a Content-Length header.
+type headerConn struct {
+	rwc io.ReadWriteCloser
+	r   *textproto.Reader
+	w   *bufio.Writer
+}
+
+func newHeaderConn(rwc io.ReadWriteCloser) *headerConn {
+	return &headerConn{rwc: rwc, r: textproto.NewReader(bufio.NewReader(rwc)), w: bufio.NewWriter(rwc)}
+}
+
+func (c *headerConn) ReadMessage() ([]byte, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > maxRequestSize {
+		return nil, fmt.Errorf("jsonrpc: invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r.R, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (c *headerConn) WriteMessage(data []byte) error {
+	setWriteDeadline(c.rwc)
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(data))
+	c.w.Write(data)
+	return c.w.Flush()
+}
+
+func (c *headerConn) Close() error {
+	return c.rwc.Close()
+}
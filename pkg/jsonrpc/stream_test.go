@@ -0,0 +1,149 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewStreamConn(t *testing.T) {
+	for _, framing := range []Framing{FramingNDJSON, FramingContentLength} {
+		t.Run(map[Framing]string{FramingNDJSON: "ndjson", FramingContentLength: "content-length"}[framing], func(t *testing.T) {
+			a, b := net.Pipe()
+
+			// the "tool" side serves arith, and calls back the "editor" side from a handler
+			tool := NewServer()
+			tool.RegisterService("arith", arith{})
+			tool.Register("ask", func(ctx context.Context, params json.RawMessage) (any, error) {
+				c, _ := ConnFromContext(ctx)
+				return Call[json.RawMessage, string](ctx, c, "editor.name", params)
+			})
+			toolConn := NewStreamConn(a, framing, Serve(tool))
+			defer toolConn.Close()
+
+			editor := NewServer()
+			editor.RegisterFunc("editor.name", func(_ context.Context, p []string) (string, error) {
+				return "editor " + p[0], nil
+			})
+			editorConn := NewStreamConn(b, framing, Serve(editor), CallTimeout(time.Second))
+			defer editorConn.Close()
+
+			logs := make(chan string, 1)
+			editorConn.HandleNotification("log", func(params json.RawMessage) { logs <- string(params) })
+
+			sum, err := Call[[]int, int](context.Background(), editorConn, "arith.sum", []int{1, 2, 3})
+			if err != nil || sum != 6 {
+				t.Errorf("sum = %d, %v", sum, err)
+			}
+
+			name, err := Call[[]string, string](context.Background(), editorConn, "ask", []string{"v1"})
+			if err != nil || name != "editor v1" {
+				t.Errorf("name = %q, %v", name, err)
+			}
+
+			if err := toolConn.Notify(context.Background(), "log", map[string]string{"msg": "hi"}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-logs:
+				if got != `{"msg":"hi"}` {
+					t.Errorf("notification params = %s", got)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no notification")
+			}
+
+			responses, err := editorConn.CallBatch(context.Background(), []*Request{
+				NewRequest("arith.sum", []int{1}, 1),
+				NewRequest("nope", nil, 2),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if responses[1].Error == nil || responses[1].Error.Code != CodeMethodNotFound {
+				t.Errorf("got %+v", responses[1])
+			}
+
+			// the peer going away fails the calls
+			toolConn.Close()
+			if _, err := Call[[]int, int](context.Background(), editorConn, "arith.sum", nil); !errors.Is(err, ErrClosed) && !errors.Is(err, ErrDisconnected) {
+				t.Errorf("err = %v after the peer closed", err)
+			}
+		})
+	}
+}
+
+func TestConn_DroppedNotifications(t *testing.T) {
+	a, b := net.Pipe()
+	tool := NewServer()
+	tool.RegisterService("arith", arith{})
+	toolConn := NewStreamConn(a, FramingNDJSON, Serve(tool))
+	defer toolConn.Close()
+	editorConn := NewStreamConn(b, FramingNDJSON, CallTimeout(time.Second))
+	defer editorConn.Close()
+
+	// the handler is stuck, the read loop keeps going
+	release := make(chan struct{})
+	var handled atomic.Int32
+	toolConn.HandleNotification("log", func(params json.RawMessage) {
+		<-release
+		handled.Add(1)
+	})
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		if err := editorConn.Notify(context.Background(), "log", []int{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Call[[]int, int](context.Background(), editorConn, "arith.sum", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	dropped := toolConn.DroppedNotifications()
+	if dropped == 0 {
+		t.Fatal("no notification dropped")
+	}
+	deadline := time.Now().Add(time.Second)
+	for uint64(handled.Load())+dropped != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d handled, %d dropped, want %d in total", handled.Load(), dropped, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNDJSONConn_MaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{name: "limit", size: maxRequestSize - 1},
+		{name: "over limit", size: maxRequestSize, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			go func() {
+				defer b.Close()
+				b.Write(append(bytes.Repeat([]byte("x"), tt.size), '\n'))
+			}()
+
+			data, err := newNDJSONConn(a).ReadMessage()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(data) != tt.size+1 {
+				t.Errorf("read %d bytes, want %d", len(data), tt.size+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package jsonrpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const writeTimeout = 10 * time.Second
+
+// wsConn carries one JSON-RPC message per WebSocket text message.
+type wsConn struct {
+	conn      *websocket.Conn
+	closeOnce sync.Once
+}
+
+func dialWebSocket(endpoint string, header http.Header) dialFunc {
+	return func(ctx context.Context) (messageConn, error) {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint, header)
+		if err != nil {
+			return nil, err
+		}
+		return &wsConn{conn: conn}, nil
+	}
+}
+
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	_, data, err := c.conn.ReadMessage()
+	return data, err
+}
+
+func (c *wsConn) WriteMessage(data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Close sends a close message before closing the connection.
+func (c *wsConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func dialTCP(addr string) dialFunc {
+	return func(ctx context.Context) (messageConn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return newNDJSONConn(conn), nil
+	}
+}
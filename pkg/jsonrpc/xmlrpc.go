@@ -0,0 +1,606 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// XML-RPC	https://xmlrpc.com/spec.md
+const (
+	xmlContentType  = "text/xml"
+	methodMulticall = "system.multicall"
+)
+
+var dateTimeLayouts = []string{
+	"20060102T15:04:05",
+	"2006-01-02T15:04:05",
+	"20060102T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+	"20060102T150405",
+	"20060102T150405Z07:00",
+}
+
+// XMLRPC makes an HTTP client speak XML-RPC instead of JSON-RPC, with the same calls:
+// positional params are sent as the params of the method call, other params as a single param,
+// results are available as JSON to GetAny and Call, and faults are returned as *Error.
+// CallBatch is sent as a system.multicall.
+//
+// Params are encoded by type: bool as boolean, integers as int (i8 beyond 32 bits),
+// floats as double, []byte as base64, time.Time as dateTime.iso8601, slices as array,
+// and maps and structs, with the field names of encoding/json, as struct.
+func XMLRPC() Option {
+	return func(o *Client) { o.xml = true }
+}
+
+func (c *Client) callXML(ctx context.Context, request *Request) (*Response, error) {
+	body, err := encodeXMLCall(request.Method, request.Params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode XML-RPC call %v: %w", request.Method, err)
+	}
+
+	data, err := c.do(ctx, body, xmlContentType, request.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := decodeXMLResponse(data)
+	resp := &Response{ID: request.ID}
+	var fault *Error
+	switch {
+	case errors.As(err, &fault):
+		resp.Error = fault
+	case err != nil:
+		return nil, fmt.Errorf("failed to decode XML-RPC response for %v: %w", request.Method, err)
+	default:
+		if resp.Result, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// callBatchXML sends requests as a system.multicall.
+func (c *Client) callBatchXML(ctx context.Context, requests []*Request) ([]*Response, error) {
+	calls := make([]any, len(requests))
+	for i, req := range requests {
+		params := positionalParams(req.Params)
+		if params == nil {
+			params = []any{}
+		}
+		calls[i] = map[string]any{"methodName": req.Method, "params": params}
+	}
+
+	resp, err := c.callXML(ctx, &Request{Method: methodMulticall, Params: []any{calls}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	var results []json.RawMessage
+	if err := json.Unmarshal(resp.Result, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", methodMulticall, err)
+	}
+	if len(results) != len(requests) {
+		return nil, fmt.Errorf("%w: %d results for %d calls", ErrMissingResponse, len(results), len(requests))
+	}
+
+	responses := make([]*Response, len(requests))
+	for i, raw := range results {
+		if requests[i].ID == nil {
+			continue
+		}
+		resp := &Response{ID: requests[i].ID}
+		// a result is wrapped in an array of one value, a fault is a struct
+		var wrapped []json.RawMessage
+		if err := json.Unmarshal(raw, &wrapped); err == nil && len(wrapped) == 1 {
+			resp.Result = wrapped[0]
+		} else {
+			var fault struct {
+				Code    int    `json:"faultCode"`
+				Message string `json:"faultString"`
+			}
+			if err := json.Unmarshal(raw, &fault); err != nil {
+				return nil, fmt.Errorf("failed to decode %s result %d: %w", methodMulticall, i, err)
+			}
+			resp.Error = &Error{Code: fault.Code, Message: fault.Message}
+		}
+		responses[i] = resp
+	}
+	return responses, nil
+}
+
+// positionalParams returns the elements of slice params, or params as the single param.
+func positionalParams(params any) []any {
+	if params == nil {
+		return nil
+	}
+	if raw, ok := params.(json.RawMessage); ok {
+		var v any
+		if err := decodeJSONNumber(raw, &v); err == nil {
+			params = v
+		}
+	}
+
+	rv := reflect.ValueOf(params)
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = rv.Index(i).Interface()
+		}
+		return list
+	}
+	return []any{params}
+}
+
+func encodeXMLCall(method string, params any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<methodCall><methodName>")
+	xml.EscapeText(&buf, []byte(method))
+	buf.WriteString("</methodName><params>")
+	for _, p := range positionalParams(params) {
+		buf.WriteString("<param>")
+		if err := encodeXMLValue(&buf, reflect.ValueOf(p)); err != nil {
+			return nil, err
+		}
+		buf.WriteString("</param>")
+	}
+	buf.WriteString("</params></methodCall>")
+	return buf.Bytes(), nil
+}
+
+var (
+	timeType   = reflect.TypeFor[time.Time]()
+	numberType = reflect.TypeFor[json.Number]()
+)
+
+func encodeXMLValue(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteString("<value>")
+	if err := encodeXMLInner(buf, v); err != nil {
+		return err
+	}
+	buf.WriteString("</value>")
+	return nil
+}
+
+// encodeXMLInner writes the typed element of a value.
+func encodeXMLInner(buf *bytes.Buffer, v reflect.Value) error {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return fmt.Errorf("XML-RPC has no nil value")
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return fmt.Errorf("XML-RPC has no nil value")
+	}
+
+	switch v.Type() {
+	case timeType:
+		fmt.Fprintf(buf, "<dateTime.iso8601>%s</dateTime.iso8601>", v.Interface().(time.Time).Format(dateTimeLayouts[0]))
+		return nil
+	case numberType:
+		n := v.Interface().(json.Number)
+		if i, err := n.Int64(); err == nil {
+			writeXMLInt(buf, i)
+		} else {
+			fmt.Fprintf(buf, "<double>%s</double>", n)
+		}
+		return nil
+	case rawMessageType:
+		var x any
+		if err := decodeJSONNumber(v.Bytes(), &x); err != nil {
+			return err
+		}
+		return encodeXMLInner(buf, reflect.ValueOf(x))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("<boolean>1</boolean>")
+		} else {
+			buf.WriteString("<boolean>0</boolean>")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeXMLInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return fmt.Errorf("XML-RPC integer overflow: %d", v.Uint())
+		}
+		writeXMLInt(buf, int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		buf.WriteString("<double>")
+		buf.WriteString(strconv.FormatFloat(v.Float(), 'f', -1, 64))
+		buf.WriteString("</double>")
+	case reflect.String:
+		buf.WriteString("<string>")
+		xml.EscapeText(buf, []byte(v.String()))
+		buf.WriteString("</string>")
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			buf.WriteString("<base64>")
+			buf.WriteString(base64.StdEncoding.EncodeToString(b))
+			buf.WriteString("</base64>")
+			return nil
+		}
+		buf.WriteString("<array><data>")
+		for i := range v.Len() {
+			if err := encodeXMLValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</data></array>")
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("XML-RPC struct keys must be strings, not %s", v.Type().Key())
+		}
+		buf.WriteString("<struct>")
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		for _, k := range keys {
+			if err := encodeXMLMember(buf, k.String(), v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</struct>")
+	case reflect.Struct:
+		buf.WriteString("<struct>")
+		if err := encodeXMLFields(buf, v); err != nil {
+			return err
+		}
+		buf.WriteString("</struct>")
+	default:
+		return fmt.Errorf("XML-RPC cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+// encodeXMLFields writes the members of struct v, named as encoding/json does.
+// Fields with omitempty are left out when empty.
+func encodeXMLFields(buf *bytes.Buffer, v reflect.Value) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+
+		if f.Anonymous && name == "" && indirect(f.Type).Kind() == reflect.Struct {
+			for fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := encodeXMLFields(buf, fv); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && fv.IsZero() {
+			continue
+		}
+		if err := encodeXMLMember(buf, name, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeXMLMember(buf *bytes.Buffer, name string, v reflect.Value) error {
+	buf.WriteString("<member><name>")
+	xml.EscapeText(buf, []byte(name))
+	buf.WriteString("</name>")
+	if err := encodeXMLValue(buf, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	buf.WriteString("</member>")
+	return nil
+}
+
+func writeXMLInt(buf *bytes.Buffer, i int64) {
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		// a common extension for 64-bit integers
+		fmt.Fprintf(buf, "<i8>%d</i8>", i)
+		return
+	}
+	fmt.Fprintf(buf, "<int>%d</int>", i)
+}
+
+func decodeJSONNumber(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// xmlDecoder decodes XML-RPC values into string, int64, float64, bool, []byte, time.Time,
+// []any, map[string]any, or nil for the nil extension.
+type xmlDecoder struct {
+	*xml.Decoder
+}
+
+// decodeXMLResponse returns the value of a method response, or the fault as a *Error.
+func decodeXMLResponse(data []byte) (any, error) {
+	d := xmlDecoder{xml.NewDecoder(bytes.NewReader(data))}
+	d.CharsetReader = charsetReader
+
+	if _, err := d.expect("methodResponse"); err != nil {
+		return nil, err
+	}
+	start, err := d.nextStart()
+	if err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "params":
+		if _, err := d.expect("param"); err != nil {
+			return nil, err
+		}
+		if _, err := d.expect("value"); err != nil {
+			return nil, err
+		}
+		return d.value()
+	case "fault":
+		if _, err := d.expect("value"); err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		fault, _ := v.(map[string]any)
+		code, _ := fault["faultCode"].(int64)
+		message, _ := fault["faultString"].(string)
+		return nil, &Error{Code: int(code), Message: message}
+	}
+	return nil, fmt.Errorf("unexpected element <%s>", start.Name.Local)
+}
+
+// nextStart returns the next start element, skipping text. It fails on end elements.
+func (d xmlDecoder) nextStart() (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, fmt.Errorf("unexpected </%s>", t.Name.Local)
+		}
+	}
+}
+
+func (d xmlDecoder) expect(name string) (xml.StartElement, error) {
+	start, err := d.nextStart()
+	if err == nil && start.Name.Local != name {
+		err = fmt.Errorf("unexpected element <%s>, want <%s>", start.Name.Local, name)
+	}
+	return start, err
+}
+
+// skipToEnd reads up to the end element of the current element, skipping text.
+func (d xmlDecoder) skipToEnd() error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return fmt.Errorf("unexpected element <%s>", t.Name.Local)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// text returns the text of the current element, up to its end.
+func (d xmlDecoder) text() (string, error) {
+	var b strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.StartElement:
+			return "", fmt.Errorf("unexpected element <%s>", t.Name.Local)
+		case xml.EndElement:
+			return b.String(), nil
+		}
+	}
+}
+
+// value decodes a value, after its <value> start element, up to its end.
+func (d xmlDecoder) value() (any, error) {
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			// a value without type is a string
+			return text.String(), nil
+		case xml.StartElement:
+			v, err := d.typed(t.Name.Local)
+			if err != nil {
+				return nil, fmt.Errorf("<%s>: %w", t.Name.Local, err)
+			}
+			return v, d.skipToEnd()
+		}
+	}
+}
+
+func (d xmlDecoder) typed(typ string) (any, error) {
+	switch typ {
+	case "struct":
+		return d.structValue()
+	case "array":
+		return d.arrayValue()
+	case "nil":
+		return nil, d.skipToEnd()
+	}
+
+	s, err := d.text()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "string":
+		return s, nil
+	case "i4", "int", "i8":
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case "boolean":
+		switch strings.TrimSpace(s) {
+		case "1", "true":
+			return true, nil
+		case "0", "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean %q", s)
+	case "double":
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	case "dateTime.iso8601":
+		for _, layout := range dateTimeLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid dateTime.iso8601 %q", s)
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	}
+	return nil, fmt.Errorf("unknown type")
+}
+
+func (d xmlDecoder) structValue() (map[string]any, error) {
+	m := make(map[string]any)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return m, nil
+		case xml.StartElement:
+			if t.Name.Local != "member" {
+				return nil, fmt.Errorf("unexpected element <%s>", t.Name.Local)
+			}
+			name, v, err := d.member()
+			if err != nil {
+				return nil, err
+			}
+			m[name] = v
+		}
+	}
+}
+
+// member decodes a struct member, whose name and value may come in any order.
+func (d xmlDecoder) member() (name string, v any, err error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return "", nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return name, v, nil
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "name":
+				if name, err = d.text(); err != nil {
+					return "", nil, err
+				}
+			case "value":
+				if v, err = d.value(); err != nil {
+					return "", nil, fmt.Errorf("member %s: %w", name, err)
+				}
+			default:
+				return "", nil, fmt.Errorf("unexpected element <%s>", t.Name.Local)
+			}
+		}
+	}
+}
+
+func (d xmlDecoder) arrayValue() ([]any, error) {
+	if _, err := d.expect("data"); err != nil {
+		return nil, err
+	}
+	list := []any{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			// </data>, then </array>
+			return list, d.skipToEnd()
+		case xml.StartElement:
+			if t.Name.Local != "value" {
+				return nil, fmt.Errorf("unexpected element <%s>", t.Name.Local)
+			}
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	}
+}
+
+// charsetReader reads ISO-8859-1, that some devices declare, as UTF-8.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "us-ascii":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 0, len(data))
+		for _, b := range data {
+			buf = utf8.AppendRune(buf, rune(b))
+		}
+		return bytes.NewReader(buf), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %s", charset)
+}
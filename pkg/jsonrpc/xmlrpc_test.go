@@ -0,0 +1,179 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func xmlServer(t *testing.T, reply func(call string) string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != xmlContentType {
+			t.Errorf("Content-Type = %s", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", xmlContentType)
+		io.WriteString(w, reply(string(body)))
+	}))
+}
+
+func TestXMLRPC_Call(t *testing.T) {
+	var gotCall string
+	ts := xmlServer(t, func(call string) string {
+		gotCall = call
+		return `<?xml version="1.0"?>
+<methodResponse>
+  <params>
+    <param>
+      <value><struct>
+        <member><name>gid</name><value><string>2089b05ecca3d829</string></value></member>
+        <member><name>untyped</name><value>a &amp; b</value></member>
+        <member><value><i4>-42</i4></value><name>i4</name></member>
+        <member><name>int</name><value><int>7</int></value></member>
+        <member><name>big</name><value><i8>8589934592</i8></value></member>
+        <member><name>ok</name><value><boolean>1</boolean></value></member>
+        <member><name>ratio</name><value><double>-12.214</double></value></member>
+        <member><name>data</name><value><base64>
+          eW91IGNhbid0IHJlYWQgdGhpcyE=
+        </base64></value></member>
+        <member><name>at</name><value><dateTime.iso8601>19980717T14:08:55</dateTime.iso8601></value></member>
+        <member><name>list</name><value><array><data>
+          <value><int>1</int></value>
+          <value><string>two</string></value>
+          <value><array><data></data></array></value>
+        </data></array></value></member>
+      </struct></value>
+    </param>
+  </params>
+</methodResponse>`
+	})
+	defer ts.Close()
+
+	type result struct {
+		GID     string    `json:"gid"`
+		Untyped string    `json:"untyped"`
+		I4      int       `json:"i4"`
+		Int     int32     `json:"int"`
+		Big     int64     `json:"big"`
+		OK      bool      `json:"ok"`
+		Ratio   float64   `json:"ratio"`
+		Data    []byte    `json:"data"`
+		At      time.Time `json:"at"`
+		List    []any     `json:"list"`
+	}
+
+	client := NewClient(ts.URL, XMLRPC())
+	type options struct {
+		Dir     string `json:"dir"`
+		Split   int    `json:"split,omitempty"`
+		Private bool   `json:"-"`
+	}
+	got, err := Call[[]any, result](context.Background(), client, "aria2.addUri", []any{
+		"token:s<3",
+		[]string{"http://a/?x=1&y=2"},
+		options{Dir: "/tmp"},
+		map[string]any{"n": uint8(3), "f": 1.5, "t": time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), "b": []byte("hi"), "ok": false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCall := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<methodCall><methodName>aria2.addUri</methodName><params>` +
+		`<param><value><string>token:s&lt;3</string></value></param>` +
+		`<param><value><array><data><value><string>http://a/?x=1&amp;y=2</string></value></data></array></value></param>` +
+		`<param><value><struct><member><name>dir</name><value><string>/tmp</string></value></member></struct></value></param>` +
+		`<param><value><struct>` +
+		`<member><name>b</name><value><base64>aGk=</base64></value></member>` +
+		`<member><name>f</name><value><double>1.5</double></value></member>` +
+		`<member><name>n</name><value><int>3</int></value></member>` +
+		`<member><name>ok</name><value><boolean>0</boolean></value></member>` +
+		`<member><name>t</name><value><dateTime.iso8601>20250102T03:04:05</dateTime.iso8601></value></member>` +
+		`</struct></value></param>` +
+		`</params></methodCall>`
+	if gotCall != wantCall {
+		t.Errorf("call\n got %s\nwant %s", gotCall, wantCall)
+	}
+
+	want := result{
+		GID: "2089b05ecca3d829", Untyped: "a & b", I4: -42, Int: 7, Big: 1 << 33, OK: true, Ratio: -12.214,
+		Data: []byte("you can't read this!"), At: time.Date(1998, 7, 17, 14, 8, 55, 0, time.UTC),
+	}
+	if got.GID != want.GID || got.Untyped != want.Untyped || got.I4 != want.I4 || got.Int != want.Int || got.Big != want.Big ||
+		got.OK != want.OK || got.Ratio != want.Ratio || string(got.Data) != string(want.Data) || !got.At.Equal(want.At) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+	if len(got.List) != 3 || got.List[1] != "two" {
+		t.Errorf("list = %v", got.List)
+	}
+}
+
+func TestXMLRPC_Fault(t *testing.T) {
+	ts := xmlServer(t, func(string) string {
+		return `<?xml version="1.0" encoding="ISO-8859-1"?>
+<methodResponse><fault><value><struct>
+  <member><name>faultCode</name><value><int>1</int></value></member>
+  <member><name>faultString</name><value><string>Unauthorized ` + "\xe9" + `</string></value></member>
+</struct></value></fault></methodResponse>`
+	})
+	defer ts.Close()
+
+	client := NewClient(ts.URL, XMLRPC())
+	_, err := Call[[]any, string](context.Background(), client, "aria2.getVersion", nil)
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != 1 || rpcErr.Message != "Unauthorized é" {
+		t.Errorf("err = %v", err)
+	}
+
+	resp, err := client.Call(context.Background(), NewRequest("aria2.getVersion", nil, 1))
+	if err != nil || resp.Error == nil || resp.ID != 1 {
+		t.Errorf("resp = %+v, err = %v", resp, err)
+	}
+}
+
+func TestXMLRPC_CallBatch(t *testing.T) {
+	ts := xmlServer(t, func(call string) string {
+		if !strings.Contains(call, "<methodName>system.multicall</methodName>") ||
+			!strings.Contains(call, "<member><name>methodName</name><value><string>aria2.tellStatus</string></value></member>") {
+			t.Errorf("call = %s", call)
+		}
+		return `<?xml version="1.0"?>
+<methodResponse><params><param><value><array><data>
+  <value><array><data><value><struct><member><name>gid</name><value><string>a</string></value></member></struct></value></data></array></value>
+  <value><struct>
+    <member><name>faultCode</name><value><int>1</int></value></member>
+    <member><name>faultString</name><value><string>GID b is not found</string></value></member>
+  </struct></value>
+  <value><array><data><value><string>OK</string></value></data></array></value>
+</data></array></value></param></params></methodResponse>`
+	})
+	defer ts.Close()
+
+	client := NewClient(ts.URL, XMLRPC())
+	responses, err := client.CallBatch(context.Background(), []*Request{
+		NewRequest("aria2.tellStatus", []string{"a"}, 1),
+		NewRequest("aria2.tellStatus", []string{"b"}, 2),
+		{Method: "aria2.saveSession"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status struct {
+		GID string `json:"gid"`
+	}
+	if err := responses[0].GetAny(&status); err != nil || status.GID != "a" {
+		t.Errorf("status = %+v, %v", status, err)
+	}
+	if responses[1].Error == nil || responses[1].Error.Message != "GID b is not found" {
+		t.Errorf("responses[1] = %+v", responses[1])
+	}
+	if responses[2] != nil {
+		t.Errorf("notification response = %+v", responses[2])
+	}
+}
@@ -0,0 +1,278 @@
+package protocol
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Client keeps a connection to a Server, reconnecting when it is lost. Responses to calls are returned by Call,
+// other packets received from the server are dispatched to the handler of their operation, or the default handler.
+//
+//	client := protocol.NewClient("localhost:9000")
+//	client.HandleDefault(func(ctx context.Context, c *protocol.Conn, pkt *protocol.Packet) {
+//		slog.Info("push", "op", pkt.Header.Operation)
+//	})
+//	resp, err := client.Call(ctx, opEcho, []byte("hello"))
+type Client struct {
+	handlers
+	addr string
+	opts *options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	conn    *Conn
+	ready   chan struct{} // closed when conn is set
+	pending map[uint32]*call
+	subs    map[string]MessageHandler // subscriptions by filter
+}
+
+// call is a call waiting for its response.
+type call struct {
+	conn *Conn
+	op   uint16
+	resp chan *Packet // closed if conn is lost
+}
+
+// answeredBy reports whether pkt, received on conn with the sequence ID of the call, is its response:
+// a reply of the operation of the call, or an error response. Version 1 and 2 packets carry no FlagReply.
+func (c *call) answeredBy(conn *Conn, pkt *Packet) bool {
+	if c.conn != conn || pkt.Header.Version >= Version3 && pkt.Header.Flags&FlagReply == 0 {
+		return false
+	}
+	return pkt.Header.Operation == c.op || pkt.Header.Operation == OpError
+}
+
+// NewClient returns a Client connecting to addr in the background: a TCP address, a WebSocket URL such as
+// "ws://localhost:8080/protocol" served by Server.ServeHTTP, or a UDP address such as "udp://localhost:9000"
+// served by Server.ServeUDP.
+// Handlers should be registered before the first packets arrive.
+func NewClient(addr string, opts ...Option) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		addr:    addr,
+		opts:    newOptions(opts),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}),
+		pending: make(map[uint32]*call),
+	}
+	go c.run()
+	return c
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	wait := c.opts.minWait
+	for {
+		nc, err := c.dial(c.ctx)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			slog.Warn("protocol: dial", "addr", c.addr, "err", err, "retryIn", wait)
+			select {
+			case <-time.After(wait):
+			case <-c.ctx.Done():
+				return
+			}
+			wait = min(2*wait, c.opts.maxWait)
+			continue
+		}
+		conn := newConn(nc, c.opts)
+		conn.handlers = &c.handlers
+		if err := conn.handshake(true); err != nil {
+			conn.Close()
+			slog.Warn("protocol: handshake", "addr", c.addr, "err", err, "retryIn", wait)
+			select {
+			case <-time.After(wait):
+			case <-c.ctx.Done():
+				return
+			}
+			wait = min(2*wait, c.opts.maxWait)
+			continue
+		}
+		wait = c.opts.minWait
+		c.setConn(conn)
+		if c.opts.onConnect != nil {
+			c.opts.onConnect(conn)
+		}
+		go c.resubscribe()
+		err = conn.serve(func(pkt *Packet) {
+			c.dispatch(conn, pkt)
+		})
+		<-conn.done
+		c.clearConn(conn)
+		if c.opts.onDisconnect != nil {
+			c.opts.onDisconnect(conn, err)
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		slog.Debug("protocol: reconnecting", "addr", c.addr, "err", err)
+	}
+}
+
+func (c *Client) setConn(conn *Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ctx.Err() != nil {
+		// closed while dialing
+		conn.Close()
+		return
+	}
+	c.conn = conn
+	close(c.ready)
+}
+
+func (c *Client) clearConn(conn *Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		c.conn = nil
+		c.ready = make(chan struct{})
+	}
+	for id, call := range c.pending {
+		if call.conn == conn {
+			delete(c.pending, id)
+			close(call.resp)
+		}
+	}
+}
+
+// dispatch resolves the responses of calls on the read loop, so that handlers can wait for them.
+func (c *Client) dispatch(conn *Conn, pkt *Packet) {
+	c.mu.Lock()
+	call, ok := c.pending[pkt.Header.SequenceID]
+	ok = ok && call.answeredBy(conn, pkt)
+	if ok {
+		delete(c.pending, pkt.Header.SequenceID)
+	}
+	c.mu.Unlock()
+	if ok {
+		// buffered, the caller is the only receiver
+		call.resp <- pkt
+		return
+	}
+
+	h, ok := c.handler(pkt.Header.Operation)
+	if !ok {
+		slog.Debug("protocol: no handler", "op", pkt.Header.Operation, "remote", conn.RemoteAddr())
+		return
+	}
+	conn.handle(func() { h(conn.Context(), conn, pkt) })
+}
+
+// Conn returns the current connection, waiting until the client is connected or ctx is done.
+func (c *Client) Conn(ctx context.Context) (*Conn, error) {
+	c.mu.Lock()
+	conn, ready := c.conn, c.ready
+	c.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+
+	select {
+	case <-ready:
+		return c.Conn(ctx)
+	case <-c.ctx.Done():
+		return nil, ErrConnClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send queues pkt on the current connection, waiting until the client is connected and the queue has room.
+// Packets queued on a connection that is then lost are not resent.
+func (c *Client) Send(ctx context.Context, pkt *Packet) error {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.SendContext(ctx, pkt)
+}
+
+// OpenStream starts a stream of packets of operation op on the current connection, waiting until the client
+// is connected. The stream fails if the connection is lost.
+func (c *Client) OpenStream(ctx context.Context, op uint16) (*StreamWriter, error) {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.OpenStream(ctx, op), nil
+}
+
+// Call sends a packet of operation op with a new sequence ID, and waits for the response with the same
+// sequence ID, sent by Conn.Reply or as an error response. If ctx has no deadline, the call times out after the WithCallTimeout timeout.
+// It returns ErrConnClosed if the connection is lost before the response arrives, and an error response as *Error.
+func (c *Client) Call(ctx context.Context, op uint16, payload []byte) (*Packet, error) {
+	if _, ok := ctx.Deadline(); !ok && c.opts.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.callTimeout)
+		defer cancel()
+	}
+
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pkt := NewPacket(op, payload)
+	id := pkt.Header.SequenceID
+	call := &call{conn: conn, op: op, resp: make(chan *Packet, 1)}
+	c.mu.Lock()
+	c.pending[id] = call
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		if c.pending[id] == call {
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+	}()
+
+	if err := conn.SendContext(ctx, pkt); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-call.resp:
+		if !ok {
+			return nil, ErrConnClosed
+		}
+		if resp.Header.Operation == OpError {
+			return nil, conn.responseError(resp)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Registry returns the registry of operations.
+func (c *Client) Registry() *Registry {
+	return c.opts.registry
+}
+
+// Close closes the connection and stops reconnecting.
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+
+	<-c.done
+	return nil
+}
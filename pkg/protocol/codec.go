@@ -1,54 +1,202 @@
 package protocol
 
 import (
+	"crypto/cipher"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type Encoder struct {
 	w io.Writer
+
+	compression       Compression
+	compressThreshold int
+	key               []byte
+	aead              cipher.AEAD
+	checksum          bool
 }
 
-func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
+func NewEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	e := &Encoder{w: w}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
+// Encode writes the packet in a single Write, so that packets written to a stream are not interleaved.
 func (e *Encoder) Encode(pkt *Packet) error {
-	// Write header
-	if err := binary.Write(e.w, binary.BigEndian, pkt.Header); err != nil {
+	b := GetPacketBuffer()
+	defer b.Release()
+
+	var err error
+	if b.B, err = e.appendPacket(b.B, pkt); err != nil {
 		return err
 	}
+	_, err = e.w.Write(b.B)
+	return err
+}
 
-	// Write payload if any
-	if len(pkt.Payload) > 0 {
-		if _, err := e.w.Write(pkt.Payload); err != nil {
-			return err
-		}
+// appendPacket appends the packet to dst, with the payload transformed by the options of the encoder.
+func (e *Encoder) appendPacket(dst []byte, pkt *Packet) ([]byte, error) {
+	size := headerSize(pkt.Header.Version)
+	if size == 0 {
+		return dst, ErrInvalidVersion
+	}
+	payload, flags, checksum, err := e.encodePayload(pkt)
+	if err != nil {
+		return dst, err
+	}
+	if len(payload) > math.MaxUint32 {
+		return dst, ErrPayloadTooLarge
 	}
 
-	return nil
+	// pkt is left as is, it may be sent to other connections concurrently
+	header := pkt.Header
+	if header.Version >= Version2 {
+		header.PayloadLength = uint32(len(payload))
+	}
+	if header.Version >= Version3 {
+		header.Flags = flags
+		header.Checksum = checksum
+	}
+
+	dst = slices.Grow(dst, size+len(payload))
+	n := len(dst)
+	if _, err := header.MarshalTo(dst[n : n+size]); err != nil {
+		return dst, err
+	}
+	return append(dst[:n+size], payload...), nil
+}
+
+// MarshalTo writes the header into buf, which must hold the header size of its version,
+// and returns the number of bytes written.
+func (h *FixedLengthHeader) MarshalTo(buf []byte) (int, error) {
+	size := headerSize(h.Version)
+	if size == 0 {
+		return 0, ErrInvalidVersion
+	}
+	if len(buf) < size {
+		return 0, io.ErrShortBuffer
+	}
+
+	binary.BigEndian.PutUint16(buf[0:], h.Version)
+	binary.BigEndian.PutUint16(buf[2:], h.Operation)
+	binary.BigEndian.PutUint32(buf[4:], h.SequenceID)
+	if h.Version >= Version2 {
+		binary.BigEndian.PutUint32(buf[8:], h.PayloadLength)
+	}
+	if h.Version >= Version3 {
+		binary.BigEndian.PutUint32(buf[12:], h.Flags)
+		binary.BigEndian.PutUint32(buf[16:], h.Checksum)
+	}
+	return size, nil
+}
+
+// unmarshalHeader reads the fields of the header in buf, that holds the header size of the version.
+func unmarshalHeader(buf []byte, h *FixedLengthHeader) {
+	*h = FixedLengthHeader{
+		Version:    binary.BigEndian.Uint16(buf[0:]),
+		Operation:  binary.BigEndian.Uint16(buf[2:]),
+		SequenceID: binary.BigEndian.Uint32(buf[4:]),
+	}
+	switch h.Version {
+	case Version3:
+		h.Flags = binary.BigEndian.Uint32(buf[12:])
+		h.Checksum = binary.BigEndian.Uint32(buf[16:])
+		fallthrough
+	case Version2:
+		h.PayloadLength = binary.BigEndian.Uint32(buf[8:])
+	}
 }
 
 type Decoder struct {
-	r io.Reader
+	r              io.Reader
+	maxPayloadSize int
+	key            []byte
+	aead           cipher.AEAD
+	zstd           *zstd.Decoder
 }
 
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r: r}
+type DecoderOption func(*Decoder)
+
+// WithMaxPayloadSize sets the max payload size, larger packets fail with ErrPayloadTooLarge.
+func WithMaxPayloadSize(size int) DecoderOption {
+	return func(d *Decoder) {
+		d.maxPayloadSize = size
+	}
 }
 
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := &Decoder{r: r, maxPayloadSize: DefaultMaxPayloadSize}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Decode reads the next packet. Version 2 and 3 packets are read one after another from a stream,
+// while the payload of a version 1 packet is the rest of the data. The payload of version 3 packets
+// is verified, decrypted and decompressed according to their flags, and Header.PayloadLength is
+// the length on the wire.
+//
+// It returns io.EOF at the end of the stream, and io.ErrUnexpectedEOF in the middle of a packet.
 func (d *Decoder) Decode(pkt *Packet) error {
-	// Read header
-	if err := binary.Read(d.r, binary.BigEndian, &pkt.Header); err != nil {
+	var header [HeaderSizeV3]byte
+
+	// Read the header fields common to all versions
+	if _, err := io.ReadFull(d.r, header[:HeaderSizeV1]); err != nil {
 		return err
 	}
+	version := binary.BigEndian.Uint16(header[0:])
 
-	// Read remaining bytes as payload
-	payload, err := io.ReadAll(d.r)
-	if err != nil {
-		return err
+	switch version {
+	case Version1:
+		unmarshalHeader(header[:HeaderSizeV1], &pkt.Header)
+		// Read remaining bytes as payload
+		payload, err := io.ReadAll(io.LimitReader(d.r, int64(d.maxPayloadSize)+1))
+		if err != nil {
+			return err
+		}
+		if len(payload) > d.maxPayloadSize {
+			return ErrPayloadTooLarge
+		}
+		pkt.Payload = payload
+		return nil
+	case Version2, Version3:
+	default:
+		unmarshalHeader(header[:HeaderSizeV1], &pkt.Header)
+		return fmt.Errorf("%w: %d", ErrInvalidVersion, version)
+	}
+
+	size := headerSize(version)
+	if _, err := io.ReadFull(d.r, header[HeaderSizeV1:size]); err != nil {
+		return noEOF(err)
+	}
+	unmarshalHeader(header[:size], &pkt.Header)
+	if int64(pkt.Header.PayloadLength) > int64(d.maxPayloadSize) {
+		return fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, pkt.Header.PayloadLength)
 	}
-	pkt.Payload = payload
 
+	pkt.Payload = make([]byte, pkt.Header.PayloadLength)
+	if _, err := io.ReadFull(d.r, pkt.Payload); err != nil {
+		return noEOF(err)
+	}
+	if pkt.Header.Flags != 0 {
+		return d.decodePayload(pkt)
+	}
 	return nil
 }
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for reads in the middle of a packet.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
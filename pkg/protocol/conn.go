@@ -0,0 +1,501 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize        = 256
+	defaultReconnectMinWait = 500 * time.Millisecond
+	defaultReconnectMaxWait = 30 * time.Second
+	defaultCallTimeout      = 30 * time.Second
+)
+
+var (
+	ErrConnClosed = errors.New("protocol: connection closed")
+	ErrQueueFull  = errors.New("protocol: send queue full")
+)
+
+// HandlerFunc handles a packet received on c. ctx is canceled when the connection is closed.
+//
+// The handlers of a connection run one at a time, in the order packets are received, off the read loop:
+// a handler can wait for the response of a Client call. Up to the queue size packets wait for a slow
+// handler, then the connection is not read until it returns.
+type HandlerFunc func(ctx context.Context, c *Conn, pkt *Packet)
+
+type options struct {
+	queueSize         int
+	encoderOptions    []EncoderOption
+	decoderOptions    []DecoderOption
+	minWait           time.Duration
+	maxWait           time.Duration
+	callTimeout       time.Duration
+	heartbeatInterval time.Duration
+	idleTimeout       time.Duration
+	writeTimeout      time.Duration
+	dial              func(ctx context.Context, network, addr string) (net.Conn, error)
+	onConnect         func(c *Conn)
+	onDisconnect      func(c *Conn, err error)
+	onDead            func(c *Conn)
+	codec             Codec
+	fragmentSize      int
+	maxMessageSize    int
+	maxBuffered       int
+	handshake         bool
+	secret            []byte
+	versions          []uint16
+	features          uint32
+	registry          *Registry
+	checkOrigin       func(r *http.Request) bool
+}
+
+// Option configures a Server or a Client.
+type Option func(*options)
+
+// WithQueueSize sets the size of the outbound queue of connections, 256 by default.
+func WithQueueSize(size int) Option {
+	return func(o *options) {
+		o.queueSize = size
+	}
+}
+
+// WithEncoderOptions sets the options of the packet encoders of connections, e.g. WithCompression.
+func WithEncoderOptions(opts ...EncoderOption) Option {
+	return func(o *options) {
+		o.encoderOptions = append(o.encoderOptions, opts...)
+	}
+}
+
+// WithDecoderOptions sets the options of the packet decoders of connections, e.g. WithMaxPayloadSize.
+func WithDecoderOptions(opts ...DecoderOption) Option {
+	return func(o *options) {
+		o.decoderOptions = append(o.decoderOptions, opts...)
+	}
+}
+
+// WithReconnect sets the min and max wait between reconnection attempts of a Client.
+// The wait doubles after each failed attempt.
+func WithReconnect(minWait, maxWait time.Duration) Option {
+	return func(o *options) {
+		o.minWait = minWait
+		o.maxWait = maxWait
+	}
+}
+
+// WithCallTimeout sets the timeout of Client calls whose context has no deadline, 30 seconds by default.
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.callTimeout = timeout
+	}
+}
+
+// WithCodec sets the codec of payloads of the typed functions Handle, Send, Decode and Call, JSON by default.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithRegistry sets the registry of operations, a new one by default.
+func WithRegistry(r *Registry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+// WithDialer sets the function a Client connects with, net.Dialer.DialContext by default.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(o *options) {
+		o.dial = dial
+	}
+}
+
+// WithOnConnect sets a hook called when a connection is established.
+func WithOnConnect(fn func(c *Conn)) Option {
+	return func(o *options) {
+		o.onConnect = fn
+	}
+}
+
+// WithOnDisconnect sets a hook called when a connection is closed, with the error that closed it.
+func WithOnDisconnect(fn func(c *Conn, err error)) Option {
+	return func(o *options) {
+		o.onDisconnect = fn
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		queueSize:         defaultQueueSize,
+		minWait:           defaultReconnectMinWait,
+		maxWait:           defaultReconnectMaxWait,
+		callTimeout:       defaultCallTimeout,
+		heartbeatInterval: defaultHeartbeatInterval,
+		idleTimeout:       defaultIdleTimeout,
+		writeTimeout:      defaultWriteTimeout,
+		fragmentSize:      defaultFragmentSize,
+		maxMessageSize:    defaultMaxMessageSize,
+		maxBuffered:       defaultMaxBuffered,
+		versions:          []uint16{Version2, Version3},
+		features:          AllFeatures,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.codec == nil {
+		o.codec = JSON
+	}
+	if o.registry == nil {
+		o.registry = NewRegistry()
+	}
+	if o.dial == nil {
+		var d net.Dialer
+		o.dial = d.DialContext
+	}
+	return o
+}
+
+// handlers maps operations to their handler.
+type handlers struct {
+	mu       sync.RWMutex
+	m        map[uint16]HandlerFunc
+	fallback HandlerFunc
+	streams  map[uint16]StreamHandlerFunc
+}
+
+// Handle sets the handler of packets of operation op. The reserved OpPing and OpPong are not dispatched.
+func (h *handlers) Handle(op uint16, fn HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.m == nil {
+		h.m = make(map[uint16]HandlerFunc)
+	}
+	h.m[op] = fn
+}
+
+// HandleStream sets the handler of streams of operation op, called in its own goroutine when a stream starts.
+// Fragmented packets of operations without a stream handler are reassembled and dispatched as one packet.
+func (h *handlers) HandleStream(op uint16, fn StreamHandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.streams == nil {
+		h.streams = make(map[uint16]StreamHandlerFunc)
+	}
+	h.streams[op] = fn
+}
+
+func (h *handlers) streamHandler(op uint16) (StreamHandlerFunc, bool) {
+	if h == nil {
+		return nil, false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	fn, ok := h.streams[op]
+	return fn, ok
+}
+
+// HandleDefault sets the handler of packets whose operation has no handler.
+func (h *handlers) HandleDefault(fn HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fallback = fn
+}
+
+func (h *handlers) handler(op uint16) (HandlerFunc, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if fn, ok := h.m[op]; ok {
+		return fn, true
+	}
+	return h.fallback, h.fallback != nil
+}
+
+// Conn is a connection carrying packets. Packets are sent through a bounded queue, written by a write loop.
+type Conn struct {
+	opts  *options
+	conn  net.Conn
+	enc   *Encoder
+	dec   *Decoder
+	queue chan *Packet
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closing   chan struct{} // closed to drain the queue and close
+	closeOnce sync.Once
+	drainOnce sync.Once
+	done      chan struct{} // closed when the write loop ended
+
+	rtt atomic.Int64
+
+	// negotiated by the handshake
+	version  uint16
+	features uint32
+
+	handlers *handlers   // of stream handlers
+	inbox    chan func() // handler calls, run by the handler worker
+
+	// outgoing streams, see stream.go
+	smu          sync.Mutex
+	outStreams   []*StreamWriter
+	nextOut      int
+	streamReady  chan struct{}
+	nextStreamID atomic.Uint32
+
+	// incoming streams, used by the read loop only
+	inStreams map[uint32]*inStream
+	buffered  atomic.Int64 // bytes of incoming streams held in memory
+
+	// UDP connections, see transport.go
+	datagram bool
+	windows  map[uint16]*seqWindow // by operation, used by the read loop only
+	dmu      sync.Mutex
+	dstats   DatagramStats
+}
+
+func newConn(nc net.Conn, o *options) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Conn{
+		opts:    o,
+		conn:    nc,
+		enc:     NewEncoder(nc, o.encoderOptions...),
+		dec:     NewDecoder(nc, o.decoderOptions...),
+		queue:   make(chan *Packet, o.queueSize),
+		inbox:   make(chan func(), o.queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+
+		version:  Version,
+		features: AllFeatures,
+
+		streamReady: make(chan struct{}, 1),
+		inStreams:   make(map[uint32]*inStream),
+	}
+	switch nc.(type) {
+	case *udpPeer, *datagramConn:
+		// fragments would be lost independently
+		c.datagram = true
+		c.features &^= FeatureFragmentation
+		c.windows = make(map[uint16]*seqWindow)
+	}
+	return c
+}
+
+// serve runs the write loop, and reads packets into dispatch until the connection is closed.
+func (c *Conn) serve(dispatch func(pkt *Packet)) error {
+	go c.writeLoop()
+	go c.handleLoop()
+	defer c.Close()
+	defer close(c.inbox)
+	defer c.abortStreams(ErrConnClosed)
+
+	for {
+		if c.opts.idleTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.opts.idleTimeout))
+		}
+		pkt := &Packet{}
+		if err := c.read(pkt); err != nil {
+			if c.ctx.Err() != nil {
+				// closed on our side
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if c.opts.onDead != nil {
+					c.opts.onDead(c)
+				}
+				return ErrIdleTimeout
+			}
+			return err
+		}
+		if c.heartbeat(pkt) {
+			continue
+		}
+		if pkt.Header.Flags&FlagFragment != 0 {
+			c.fragment(pkt, dispatch)
+			continue
+		}
+		dispatch(pkt)
+	}
+}
+
+// handle queues a handler call for the handler worker, called by the read loop only.
+func (c *Conn) handle(fn func()) {
+	select {
+	case c.inbox <- fn:
+	case <-c.ctx.Done():
+	}
+}
+
+// handleLoop runs the handler calls until the read loop ends.
+func (c *Conn) handleLoop() {
+	for fn := range c.inbox {
+		fn()
+	}
+}
+
+func (c *Conn) writeLoop() {
+	defer close(c.done)
+	defer c.Close()
+
+	var ping <-chan time.Time
+	if c.opts.heartbeatInterval > 0 {
+		ticker := time.NewTicker(c.opts.heartbeatInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		// packets and pings go before fragments, streams take turns
+		var pkt *Packet
+		select {
+		case <-ping:
+			pkt = newPing()
+		case pkt = <-c.queue:
+		default:
+			pkt = c.nextFragment()
+		}
+		if pkt == nil {
+			select {
+			case <-ping:
+				pkt = newPing()
+			case pkt = <-c.queue:
+			case <-c.streamReady:
+				continue
+			case <-c.closing:
+				// send what is queued, then close
+				for {
+					select {
+					case pkt := <-c.queue:
+						if c.write(pkt) != nil {
+							return
+						}
+					default:
+						return
+					}
+				}
+			case <-c.ctx.Done():
+				return
+			}
+		}
+
+		if err := c.write(pkt); err != nil {
+			slog.Debug("protocol: write", "remote", c.RemoteAddr(), "op", pkt.Header.Operation, "err", err)
+			if c.datagram && c.ctx.Err() == nil {
+				// e.g. a packet too large for a datagram
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (c *Conn) write(pkt *Packet) error {
+	if pkt.Header.Version > c.version {
+		// not supported by the peer
+		downgraded := *pkt
+		downgraded.Header.Version = c.version
+		pkt = &downgraded
+	}
+	if c.opts.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.opts.writeTimeout))
+	}
+	return c.enc.Encode(pkt)
+}
+
+// Send queues pkt, without waiting. It returns ErrQueueFull if the outbound queue is full.
+func (c *Conn) Send(pkt *Packet) error {
+	if err := pkt.validate(); err != nil {
+		return err
+	}
+	select {
+	case <-c.closing:
+		return ErrConnClosed
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.queue <- pkt:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// SendContext queues pkt, waiting for room in the outbound queue until ctx is done.
+func (c *Conn) SendContext(ctx context.Context, pkt *Packet) error {
+	if err := pkt.validate(); err != nil {
+		return err
+	}
+	select {
+	case <-c.closing:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.queue <- pkt:
+		return nil
+	case <-c.closing:
+		return ErrConnClosed
+	case <-c.ctx.Done():
+		return ErrConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reply queues a response to req, with its operation and sequence ID, and FlagReply.
+func (c *Conn) Reply(req *Packet, payload []byte) error {
+	return c.Send(NewPacket(req.Header.Operation, payload, replyTo(req)))
+}
+
+// Codec returns the codec of payloads.
+func (c *Conn) Codec() Codec {
+	return c.opts.codec
+}
+
+// Context returns the context of the connection, canceled when it is closed.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// Close closes the connection, dropping the queued packets.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.conn.Close()
+	})
+	return nil
+}
+
+// drain stops accepting packets, writes the queued ones and closes the connection.
+func (c *Conn) drain() {
+	c.drainOnce.Do(func() {
+		close(c.closing)
+	})
+}
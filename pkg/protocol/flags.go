@@ -0,0 +1,263 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/Lysander66/zephyr/pkg/zcrypto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Flags of version 3 headers, describing how the payload is transformed on the wire.
+const (
+	// FlagChecksum is set when the header carries the CRC32C of the payload on the wire
+	FlagChecksum uint32 = 1 << 0
+	// FlagEncrypted is set when the payload is encrypted with AES-GCM
+	FlagEncrypted uint32 = 1 << 1
+
+	// the Compression of the payload is stored in bits 8-11
+	flagCompressionShift        = 8
+	flagCompressionMask  uint32 = 0xF << flagCompressionShift
+)
+
+// FlagReply is set on responses, by Reply and error responses, so that they are told apart from the packets
+// of the peer with the same sequence ID.
+const FlagReply uint32 = 1 << 4
+
+var ErrChecksum = errors.New("payload checksum mismatch")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Compression is a payload compression algorithm.
+type Compression uint8
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Zstd
+	Snappy
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+// CompressionOf returns the compression set in flags.
+func CompressionOf(flags uint32) Compression {
+	return Compression((flags & flagCompressionMask) >> flagCompressionShift)
+}
+
+type EncoderOption func(*Encoder)
+
+// WithCompression compresses payloads of at least threshold bytes, when compression makes them smaller.
+func WithCompression(c Compression, threshold int) EncoderOption {
+	return func(e *Encoder) {
+		e.compression = c
+		e.compressThreshold = threshold
+	}
+}
+
+// WithEncryption encrypts payloads with AES-GCM, e.g. with a key of zcrypto.RandomKey(32).
+// The decoder needs the same key, with WithDecryption.
+func WithEncryption(key []byte) EncoderOption {
+	return func(e *Encoder) {
+		e.key = key
+	}
+}
+
+// WithChecksum adds the CRC32C of payloads to headers, verified by decoders.
+func WithChecksum() EncoderOption {
+	return func(e *Encoder) {
+		e.checksum = true
+	}
+}
+
+// WithDecryption sets the key of encrypted payloads, the one of WithEncryption.
+func WithDecryption(key []byte) DecoderOption {
+	return func(d *Decoder) {
+		d.key = key
+	}
+}
+
+// encodePayload applies the transforms of the encoder to the payload, and returns it with its flags and checksum.
+// Version 1 and 2 packets carry no flags, their payload is not transformed.
+func (e *Encoder) encodePayload(pkt *Packet) (payload []byte, flags, checksum uint32, err error) {
+	payload = pkt.Payload
+	if pkt.Header.Version < Version3 {
+		return payload, 0, 0, nil
+	}
+	flags = pkt.Header.Flags & packetFlags
+
+	if e.compression != NoCompression && len(payload) >= e.compressThreshold {
+		compressed, err := compress(e.compression, payload)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if len(compressed) < len(payload) {
+			payload = compressed
+			flags |= uint32(e.compression) << flagCompressionShift
+		}
+	}
+
+	if e.key != nil {
+		if e.aead == nil {
+			if e.aead, err = zcrypto.NewAESGCM(e.key); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		if payload, err = zcrypto.GCMSeal(e.aead, payload, additionalData(&pkt.Header)); err != nil {
+			return nil, 0, 0, err
+		}
+		flags |= FlagEncrypted
+	}
+
+	if e.checksum {
+		checksum = crc32.Checksum(payload, crc32c)
+		flags |= FlagChecksum
+	}
+	return payload, flags, checksum, nil
+}
+
+// decodePayload reverses the transforms of the flags of the header.
+func (d *Decoder) decodePayload(pkt *Packet) error {
+	flags := pkt.Header.Flags
+	if flags&FlagChecksum != 0 && crc32.Checksum(pkt.Payload, crc32c) != pkt.Header.Checksum {
+		return ErrChecksum
+	}
+
+	if flags&FlagEncrypted != 0 {
+		if d.key == nil {
+			return fmt.Errorf("%w: encrypted, no key", ErrInvalidPayload)
+		}
+		if d.aead == nil {
+			var err error
+			if d.aead, err = zcrypto.NewAESGCM(d.key); err != nil {
+				return err
+			}
+		}
+		payload, err := zcrypto.GCMOpen(d.aead, pkt.Payload, additionalData(&pkt.Header))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		pkt.Payload = payload
+	}
+
+	if c := CompressionOf(flags); c != NoCompression {
+		payload, err := d.decompress(c, pkt.Payload)
+		if err != nil {
+			return err
+		}
+		pkt.Payload = payload
+	}
+	return nil
+}
+
+// additionalData authenticates the header fields that identify the packet along with an encrypted payload.
+func additionalData(h *FixedLengthHeader) []byte {
+	ad := make([]byte, 0, 8)
+	ad = binary.BigEndian.AppendUint16(ad, h.Version)
+	ad = binary.BigEndian.AppendUint16(ad, h.Operation)
+	return binary.BigEndian.AppendUint32(ad, h.SequenceID)
+}
+
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+)
+
+func compress(c Compression, payload []byte) ([]byte, error) {
+	switch c {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		zstdEncoderOnce.Do(func() {
+			// EncodeAll can be called concurrently
+			zstdEncoder, _ = zstd.NewWriter(nil)
+		})
+		return zstdEncoder.EncodeAll(payload, nil), nil
+	case Snappy:
+		return snappy.Encode(nil, payload), nil
+	}
+	return nil, fmt.Errorf("unknown %s", c)
+}
+
+// decompress decompresses payload, failing with ErrPayloadTooLarge beyond the max payload size.
+func (d *Decoder) decompress(c Compression, payload []byte) ([]byte, error) {
+	switch c {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		return d.readLimited(r)
+	case Zstd:
+		if d.zstd == nil {
+			var err error
+			d.zstd, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(d.maxPayloadSize)))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := d.zstd.Reset(bytes.NewReader(payload)); err != nil {
+			return nil, decompressError(err)
+		}
+		return d.readLimited(d.zstd)
+	case Snappy:
+		n, err := snappy.DecodedLen(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		if n > d.maxPayloadSize {
+			return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, n)
+		}
+		payload, err = snappy.Decode(nil, payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		return payload, nil
+	}
+	return nil, fmt.Errorf("%w: unknown %s", ErrInvalidPayload, c)
+}
+
+func (d *Decoder) readLimited(r io.Reader) ([]byte, error) {
+	payload, err := io.ReadAll(io.LimitReader(r, int64(d.maxPayloadSize)+1))
+	if err != nil {
+		return nil, decompressError(err)
+	}
+	if len(payload) > d.maxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	return payload, nil
+}
+
+func decompressError(err error) error {
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return fmt.Errorf("%w: %v", ErrPayloadTooLarge, err)
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+}
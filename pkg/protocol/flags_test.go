@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Lysander66/zephyr/pkg/zcrypto"
+)
+
+func TestEncoderFlags(t *testing.T) {
+	key, err := zcrypto.RandomKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte("compressible "), 100)
+
+	tests := []struct {
+		name      string
+		opts      []EncoderOption
+		payload   []byte
+		wantFlags uint32
+	}{
+		{"none", nil, large, 0},
+		{"gzip", []EncoderOption{WithCompression(Gzip, 64)}, large, uint32(Gzip) << flagCompressionShift},
+		{"zstd", []EncoderOption{WithCompression(Zstd, 64)}, large, uint32(Zstd) << flagCompressionShift},
+		{"snappy", []EncoderOption{WithCompression(Snappy, 64)}, large, uint32(Snappy) << flagCompressionShift},
+		{"below threshold", []EncoderOption{WithCompression(Zstd, 64)}, []byte("small"), 0},
+		{"checksum", []EncoderOption{WithChecksum()}, large, FlagChecksum},
+		{"encrypted", []EncoderOption{WithEncryption(key)}, large, FlagEncrypted},
+		{
+			"all",
+			[]EncoderOption{WithCompression(Zstd, 64), WithEncryption(key), WithChecksum()},
+			large,
+			uint32(Zstd)<<flagCompressionShift | FlagEncrypted | FlagChecksum,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			pkt := NewPacket(1, tt.payload)
+			sent := *pkt
+			if err := NewEncoder(&buf, tt.opts...).Encode(pkt); err != nil {
+				t.Fatal(err)
+			}
+			if pkt.Header != sent.Header {
+				t.Errorf("encoded packet header = %+v, want %+v", pkt.Header, sent.Header)
+			}
+
+			var got Packet
+			if err := NewDecoder(&buf, WithDecryption(key)).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Header.Flags != tt.wantFlags {
+				t.Errorf("flags = %#x, want %#x", got.Header.Flags, tt.wantFlags)
+			}
+			if CompressionOf(tt.wantFlags) != NoCompression && int(got.Header.PayloadLength) >= len(tt.payload) {
+				t.Errorf("payload length = %d, not compressed", got.Header.PayloadLength)
+			}
+			if got.Header.SequenceID != pkt.Header.SequenceID || !bytes.Equal(got.Payload, tt.payload) {
+				t.Errorf("got %+v, want %+v", got.Header, pkt.Header)
+			}
+		})
+	}
+}
+
+func TestDecoderFlags_Invalid(t *testing.T) {
+	key, _ := zcrypto.RandomKey(32)
+	encode := func(pkt *Packet, opts ...EncoderOption) []byte {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf, opts...).Encode(pkt); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	corrupted := encode(NewPacket(1, []byte("hello")), WithChecksum())
+	corrupted[len(corrupted)-1] ^= 1
+
+	// the same ciphertext under another operation
+	encrypted := encode(NewPacket(1, []byte("hello")), WithEncryption(key))
+	otherOp := bytes.Clone(encrypted)
+	otherOp[3] = 2
+
+	bomb := encode(NewPacket(1, make([]byte, 1<<20)), WithCompression(Zstd, 0))
+
+	tests := []struct {
+		name string
+		data []byte
+		opts []DecoderOption
+		want error
+	}{
+		{"checksum", corrupted, nil, ErrChecksum},
+		{"no key", encrypted, nil, ErrInvalidPayload},
+		{"wrong key", encrypted, []DecoderOption{WithDecryption(make([]byte, 32))}, ErrInvalidPayload},
+		{"header tampered", otherOp, []DecoderOption{WithDecryption(key)}, ErrInvalidPayload},
+		{"decompressed too large", bomb, []DecoderOption{WithMaxPayloadSize(1 << 16)}, ErrPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pkt Packet
+			if err := NewDecoder(bytes.NewReader(tt.data), tt.opts...).Decode(&pkt); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderFlags_OldVersions(t *testing.T) {
+	// version 1 and 2 packets have no flags, their payload is sent as is
+	for _, version := range []uint16{Version1, Version2} {
+		pkt := NewPacket(1, bytes.Repeat([]byte("x"), 100), WithVersion(version))
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf, WithCompression(Gzip, 0), WithChecksum()).Encode(pkt); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Unpack(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Payload, pkt.Payload) {
+			t.Errorf("version %d: payload transformed", version)
+		}
+	}
+}
+
+func TestServerClientFlags(t *testing.T) {
+	key, _ := zcrypto.RandomKey(16)
+	opts := []Option{
+		WithEncoderOptions(WithCompression(Snappy, 16), WithEncryption(key), WithChecksum()),
+		WithDecoderOptions(WithDecryption(key)),
+	}
+	_, addr := newTestServer(t, opts...)
+	client := NewClient(addr, opts...)
+	defer client.Close()
+
+	payload := bytes.Repeat([]byte("hello "), 100)
+	resp, err := client.Call(context.Background(), opEcho, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.Payload, payload) {
+		t.Errorf("payload = %q", resp.Payload)
+	}
+	if want := uint32(Snappy)<<flagCompressionShift | FlagEncrypted | FlagChecksum | FlagReply; resp.Header.Flags != want {
+		t.Errorf("flags = %#x, want %#x", resp.Header.Flags, want)
+	}
+}
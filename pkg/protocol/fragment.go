@@ -0,0 +1,409 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// Flags of fragments of streams, set by StreamWriter. The payload of a fragment starts with a fragment header:
+//
+//	StreamID (4 bytes), Index (4 bytes)
+//
+// Stream IDs are chosen by the sender, index is 0 for the first fragment of a stream.
+const (
+	FlagFragment     uint32 = 1 << 2
+	FlagLastFragment uint32 = 1 << 3
+
+	fragmentFlags = FlagFragment | FlagLastFragment
+	// flags of the packet, kept by the encoder
+	packetFlags = fragmentFlags | FlagReply
+)
+
+const (
+	fragmentHeaderSize = 8
+
+	defaultFragmentSize   = 32 << 10
+	defaultMaxMessageSize = 16 << 20
+	defaultMaxBuffered    = 32 << 20
+
+	// fragments queued per stream before Write blocks
+	streamQueueSize = 4
+	// incoming streams open at once on a connection, the fragments of further streams are dropped
+	maxInStreams = 256
+)
+
+var ErrStreamAborted = errors.New("protocol: stream aborted")
+
+// StreamHandlerFunc handles a stream received on c, reading its data from r.
+// The rest of the stream is discarded when it returns.
+type StreamHandlerFunc func(ctx context.Context, c *Conn, r *StreamReader)
+
+// WithFragmentSize sets the max size of the data of the fragments of streams, 32 KB by default.
+func WithFragmentSize(size int) Option {
+	return func(o *options) {
+		o.fragmentSize = size
+	}
+}
+
+// WithReassemblyLimits sets the max size of a reassembled packet, 16 MB by default, and the max bytes of
+// incoming streams held in memory by a connection, 32 MB by default. Streams exceeding them are aborted.
+func WithReassemblyLimits(maxMessageSize, maxBuffered int) Option {
+	return func(o *options) {
+		o.maxMessageSize = maxMessageSize
+		o.maxBuffered = maxBuffered
+	}
+}
+
+// StreamWriter sends the data written to it as fragments of packets of one operation.
+// It must be closed to send the last fragment. Streams are interleaved with each other
+// one fragment at a time, and packets sent with Send go before fragments.
+type StreamWriter struct {
+	c     *Conn
+	ctx   context.Context
+	op    uint16
+	id    uint32
+	index uint32
+	buf   []byte
+
+	frags  chan *Packet
+	err    error
+	closed bool
+}
+
+// OpenStream starts a stream of packets of operation op. ctx bounds the writes to the stream.
+func (c *Conn) OpenStream(ctx context.Context, op uint16) *StreamWriter {
+	w := &StreamWriter{
+		c:     c,
+		ctx:   ctx,
+		op:    op,
+		id:    c.nextStreamID.Add(1),
+		buf:   make([]byte, fragmentHeaderSize, fragmentHeaderSize+c.opts.fragmentSize),
+		frags: make(chan *Packet, streamQueueSize),
+	}
+
+	if c.features&FeatureFragmentation == 0 {
+		w.err = ErrNotNegotiated
+		return w
+	}
+
+	c.smu.Lock()
+	c.outStreams = append(c.outStreams, w)
+	c.smu.Unlock()
+	return w
+}
+
+// ID returns the stream ID.
+func (w *StreamWriter) ID() uint32 {
+	return w.id
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		k := min(len(p), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		n += k
+		if len(w.buf) == cap(w.buf) {
+			if w.err = w.flush(false); w.err != nil {
+				return n, w.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close sends the last fragment, with the data not sent yet.
+func (w *StreamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err != nil {
+		w.c.removeStream(w)
+		return w.err
+	}
+	if err := w.flush(true); err != nil {
+		w.c.removeStream(w)
+		return err
+	}
+	return nil
+}
+
+// flush queues the buffered data as a fragment.
+func (w *StreamWriter) flush(last bool) error {
+	binary.BigEndian.PutUint32(w.buf[0:], w.id)
+	binary.BigEndian.PutUint32(w.buf[4:], w.index)
+	pkt := NewPacket(w.op, w.buf)
+	pkt.Header.Flags = FlagFragment
+	if last {
+		pkt.Header.Flags |= FlagLastFragment
+	}
+
+	select {
+	case w.frags <- pkt:
+	case <-w.c.closing:
+		return ErrConnClosed
+	case <-w.c.ctx.Done():
+		return ErrConnClosed
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+	select {
+	case w.c.streamReady <- struct{}{}:
+	default:
+	}
+
+	w.index++
+	if !last {
+		w.buf = make([]byte, fragmentHeaderSize, cap(w.buf))
+	}
+	return nil
+}
+
+// nextFragment returns a queued fragment of the next stream that has one, nil if none.
+func (c *Conn) nextFragment() *Packet {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	n := len(c.outStreams)
+	for i := range n {
+		j := (c.nextOut + i) % n
+		w := c.outStreams[j]
+		select {
+		case pkt := <-w.frags:
+			c.nextOut = j + 1
+			if pkt.Header.Flags&FlagLastFragment != 0 {
+				c.outStreams = append(c.outStreams[:j], c.outStreams[j+1:]...)
+				c.nextOut = j
+			}
+			return pkt
+		default:
+		}
+	}
+	return nil
+}
+
+func (c *Conn) removeStream(w *StreamWriter) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	for i, s := range c.outStreams {
+		if s == w {
+			c.outStreams = append(c.outStreams[:i], c.outStreams[i+1:]...)
+			return
+		}
+	}
+}
+
+// StreamReader reads the data of a stream received on a connection.
+type StreamReader struct {
+	c  *Conn
+	op uint16
+	id uint32
+
+	mu      sync.Mutex
+	chunks  [][]byte
+	err     error         // io.EOF after the last fragment
+	ready   chan struct{} // signaled when chunks or err are set
+	handled bool          // the handler returned, data is dropped
+}
+
+// ID returns the stream ID.
+func (r *StreamReader) ID() uint32 {
+	return r.id
+}
+
+// Operation returns the operation of the packets of the stream.
+func (r *StreamReader) Operation() uint16 {
+	return r.op
+}
+
+func (r *StreamReader) Read(p []byte) (int, error) {
+	for {
+		r.mu.Lock()
+		if len(r.chunks) > 0 {
+			n := copy(p, r.chunks[0])
+			if n == len(r.chunks[0]) {
+				r.chunks = r.chunks[1:]
+			} else {
+				r.chunks[0] = r.chunks[0][n:]
+			}
+			r.mu.Unlock()
+			r.c.buffered.Add(-int64(n))
+			return n, nil
+		}
+		err := r.err
+		r.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-r.ready:
+		case <-r.c.ctx.Done():
+			// the read loop sets err when it ends
+			<-r.ready
+		}
+	}
+}
+
+func (r *StreamReader) push(data []byte) {
+	r.mu.Lock()
+	if r.handled {
+		r.c.buffered.Add(-int64(len(data)))
+	} else {
+		r.chunks = append(r.chunks, data)
+	}
+	r.mu.Unlock()
+	r.signal()
+}
+
+// discard drops the data not read by the handler, and the data still to come.
+func (r *StreamReader) discard() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handled = true
+	r.drop()
+	if r.err == nil {
+		r.err = ErrStreamAborted
+	}
+}
+
+// end sets the error returned after the data, and drops the data unless err is io.EOF.
+func (r *StreamReader) end(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	if r.err != io.EOF {
+		r.drop()
+	}
+	r.mu.Unlock()
+	r.signal()
+}
+
+func (r *StreamReader) drop() {
+	for _, chunk := range r.chunks {
+		r.c.buffered.Add(-int64(len(chunk)))
+	}
+	r.chunks = nil
+}
+
+func (r *StreamReader) signal() {
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// inStream is the state of an incoming stream in the read loop.
+type inStream struct {
+	id     uint32
+	first  FixedLengthHeader
+	next   uint32
+	reader *StreamReader // nil when reassembling
+	buf    []byte
+}
+
+// fragment handles a fragment received by the read loop, passing reassembled packets to dispatch.
+func (c *Conn) fragment(pkt *Packet, dispatch func(pkt *Packet)) {
+	if len(pkt.Payload) < fragmentHeaderSize {
+		slog.Debug("protocol: short fragment", "remote", c.RemoteAddr(), "op", pkt.Header.Operation)
+		return
+	}
+	id := binary.BigEndian.Uint32(pkt.Payload[0:])
+	index := binary.BigEndian.Uint32(pkt.Payload[4:])
+	data := pkt.Payload[fragmentHeaderSize:]
+	last := pkt.Header.Flags&FlagLastFragment != 0
+
+	s, ok := c.inStreams[id]
+	if !ok {
+		switch {
+		case index != 0:
+			// the start of the stream was missed, or the stream was aborted
+			slog.Debug("protocol: fragment of no stream", "remote", c.RemoteAddr(), "op", pkt.Header.Operation, "index", index)
+			return
+		case len(c.inStreams) >= maxInStreams:
+			slog.Debug("protocol: too many streams, stream dropped", "remote", c.RemoteAddr(), "op", pkt.Header.Operation)
+			return
+		}
+		s = &inStream{id: id, first: pkt.Header}
+		c.inStreams[id] = s
+		if h, ok := c.handlers.streamHandler(pkt.Header.Operation); ok {
+			s.reader = &StreamReader{c: c, op: pkt.Header.Operation, id: id, ready: make(chan struct{}, 1)}
+			go func(r *StreamReader) {
+				defer r.discard()
+				h(c.ctx, c, r)
+			}(s.reader)
+		}
+	}
+	if last {
+		delete(c.inStreams, id)
+	}
+
+	switch {
+	case index != s.next:
+		c.abortStream(s, fmt.Errorf("%w: fragment %d, want %d", ErrStreamAborted, index, s.next))
+		return
+	case s.reader == nil && len(s.buf)+len(data) > c.opts.maxMessageSize:
+		c.abortStream(s, fmt.Errorf("%w: %w", ErrStreamAborted, ErrPayloadTooLarge))
+		return
+	case c.buffered.Load()+int64(len(data)) > int64(c.opts.maxBuffered):
+		c.abortStream(s, fmt.Errorf("%w: reassembly buffers full", ErrStreamAborted))
+		return
+	}
+	s.next++
+	c.buffered.Add(int64(len(data)))
+
+	if s.reader != nil {
+		if len(data) > 0 {
+			s.reader.push(data)
+		}
+		if last {
+			s.reader.end(io.EOF)
+		}
+		return
+	}
+
+	s.buf = append(s.buf, data...)
+	if last {
+		c.buffered.Add(-int64(len(s.buf)))
+		header := s.first
+		header.Flags &^= fragmentFlags
+		header.PayloadLength = uint32(len(s.buf))
+		dispatch(&Packet{Header: header, Payload: s.buf})
+	}
+}
+
+// abortStream ends an incoming stream with err, its next fragments are dropped.
+func (c *Conn) abortStream(s *inStream, err error) {
+	slog.Debug("protocol: stream aborted", "remote", c.RemoteAddr(), "op", s.first.Operation, "err", err)
+	delete(c.inStreams, s.id)
+	if s.reader != nil {
+		s.reader.end(err)
+	} else {
+		c.buffered.Add(-int64(len(s.buf)))
+		s.buf = nil
+	}
+}
+
+// abortStreams aborts the incoming streams when the read loop ends.
+func (c *Conn) abortStreams(err error) {
+	for _, s := range c.inStreams {
+		c.abortStream(s, err)
+	}
+}
@@ -0,0 +1,246 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+const opUpload uint16 = 20
+
+func TestStream(t *testing.T) {
+	type upload struct {
+		data []byte
+		err  error
+	}
+	uploads := make(chan upload, 2)
+	s, addr := newTestServer(t, WithFragmentSize(1000))
+	s.HandleStream(opUpload, func(ctx context.Context, c *Conn, r *StreamReader) {
+		data, err := io.ReadAll(r)
+		uploads <- upload{data, err}
+	})
+
+	client := NewClient(addr, WithFragmentSize(1000))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	w, err := client.OpenStream(ctx, opUpload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for chunk := range slicesChunk(data, 777) {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case u := <-uploads:
+		if u.err != nil {
+			t.Fatal(u.err)
+		}
+		if !bytes.Equal(u.data, data) {
+			t.Errorf("received %d bytes, want %d", len(u.data), len(data))
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+}
+
+func slicesChunk(data []byte, n int) func(yield func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(data) > 0 {
+			k := min(n, len(data))
+			if !yield(data[:k]) {
+				return
+			}
+			data = data[k:]
+		}
+	}
+}
+
+func TestReassembly(t *testing.T) {
+	s, addr := newTestServer(t, WithReassemblyLimits(5000, 1<<20))
+	received := make(chan int, 1)
+	s.Handle(opUpload, func(ctx context.Context, c *Conn, pkt *Packet) {
+		received <- len(pkt.Payload)
+	})
+
+	client := NewClient(addr, WithFragmentSize(1000))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	send := func(size int) {
+		w, err := client.OpenStream(ctx, opUpload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(make([]byte, size))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// too large, dropped
+	send(6000)
+	// reassembled into one packet
+	send(4500)
+	select {
+	case n := <-received:
+		if n != 4500 {
+			t.Errorf("received %d bytes, want 4500", n)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// a reassembled request gets its response like any other
+	resp, err := client.Call(ctx, opEcho, []byte("still alive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload) != "still alive" {
+		t.Errorf("payload = %q", resp.Payload)
+	}
+}
+
+func TestStreamInterleaving(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := newConn(a, newOptions([]Option{WithFragmentSize(10), WithHeartbeat(0, 0)}))
+	defer c.Close()
+
+	ctx := context.Background()
+	w1, w2 := c.OpenStream(ctx, opUpload), c.OpenStream(ctx, opUpload)
+	for _, w := range []*StreamWriter{w1, w2} {
+		// three fragments each, queued before the write loop starts
+		if _, err := w.Write(make([]byte, 30)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Send(NewPacket(opPush, nil)); err != nil {
+		t.Fatal(err)
+	}
+	go c.writeLoop()
+
+	dec := NewDecoder(b)
+	var got []string
+	for range 7 {
+		var pkt Packet
+		if err := dec.Decode(&pkt); err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Header.Flags&FlagFragment == 0 {
+			got = append(got, "control")
+			continue
+		}
+		id := binary.BigEndian.Uint32(pkt.Payload)
+		index := binary.BigEndian.Uint32(pkt.Payload[4:])
+		got = append(got, string(rune('a'+id-w1.ID()))+string(rune('0'+index)))
+	}
+	want := []string{"control", "a0", "b0", "a1", "b1", "a2", "b2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestStreamReaderAborted(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := newConn(a, newOptions([]Option{WithReassemblyLimits(1<<20, 100)}))
+	defer c.Close()
+	c.handlers = &handlers{}
+	readers := make(chan *StreamReader, 1)
+	c.handlers.HandleStream(opUpload, func(ctx context.Context, c *Conn, r *StreamReader) {
+		readers <- r
+		<-ctx.Done()
+	})
+
+	fragment := func(index uint32, size int, last bool) *Packet {
+		payload := binary.BigEndian.AppendUint32(nil, 1)
+		payload = binary.BigEndian.AppendUint32(payload, index)
+		pkt := NewPacket(opUpload, append(payload, make([]byte, size)...))
+		pkt.Header.Flags = FlagFragment
+		if last {
+			pkt.Header.Flags |= FlagLastFragment
+		}
+		return pkt
+	}
+	dispatch := func(pkt *Packet) { t.Errorf("dispatched %+v", pkt.Header) }
+
+	c.fragment(fragment(0, 60, false), dispatch)
+	r := <-readers
+	// beyond the 100 bytes held in memory
+	c.fragment(fragment(1, 60, false), dispatch)
+	c.fragment(fragment(2, 60, true), dispatch)
+
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrStreamAborted) {
+		t.Errorf("err = %v, want ErrStreamAborted", err)
+	}
+	if n := c.buffered.Load(); n != 0 {
+		t.Errorf("buffered = %d, want 0", n)
+	}
+	if n := len(c.inStreams); n != 0 {
+		t.Errorf("%d streams kept, want 0", n)
+	}
+}
+
+func TestInStreamsLimits(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := newConn(a, newOptions(nil))
+	defer c.Close()
+	c.handlers = &handlers{}
+
+	fragment := func(id, index uint32, last bool) *Packet {
+		payload := binary.BigEndian.AppendUint32(nil, id)
+		payload = binary.BigEndian.AppendUint32(payload, index)
+		pkt := NewPacket(opUpload, append(payload, 'x'))
+		pkt.Header.Flags = FlagFragment
+		if last {
+			pkt.Header.Flags |= FlagLastFragment
+		}
+		return pkt
+	}
+	dispatched := 0
+	dispatch := func(pkt *Packet) { dispatched++ }
+
+	// streams whose start was missed are not kept
+	c.fragment(fragment(1, 3, false), dispatch)
+	c.fragment(fragment(1, 4, true), dispatch)
+	if n := len(c.inStreams); n != 0 {
+		t.Errorf("%d streams kept, want 0", n)
+	}
+
+	// the streams beyond the limit are dropped
+	for id := range uint32(maxInStreams + 1) {
+		c.fragment(fragment(id, 0, false), dispatch)
+	}
+	if n := len(c.inStreams); n != maxInStreams {
+		t.Errorf("%d streams kept, want %d", n, maxInStreams)
+	}
+	c.fragment(fragment(maxInStreams, 1, true), dispatch)
+	c.fragment(fragment(0, 1, true), dispatch)
+	if dispatched != 1 || len(c.inStreams) != maxInStreams-1 {
+		t.Errorf("%d packets dispatched, %d streams kept, want 1, %d", dispatched, len(c.inStreams), maxInStreams-1)
+	}
+
+	// an aborted stream is removed, its next fragments are dropped
+	c.fragment(fragment(1, 5, false), dispatch)
+	c.fragment(fragment(1, 6, true), dispatch)
+	if _, ok := c.inStreams[1]; ok || dispatched != 1 {
+		t.Errorf("aborted stream kept, %d packets dispatched", dispatched)
+	}
+}
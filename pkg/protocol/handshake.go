@@ -0,0 +1,268 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Lysander66/zephyr/pkg/zcrypto"
+)
+
+// Reserved operations of the handshake, sent as version 2 packets that every peer can decode.
+const (
+	// OpHello carries the versions and features of the client, then the ones chosen by the server
+	OpHello uint16 = 0xFFFC
+	// OpAuth carries the answer of the client to the challenge of the server
+	OpAuth uint16 = 0xFFFB
+)
+
+// Features negotiated by the handshake. They need version 3 packets.
+const (
+	FeatureCompression uint32 = 1 << iota
+	FeatureEncryption
+	FeatureFragmentation
+
+	AllFeatures = FeatureCompression | FeatureEncryption | FeatureFragmentation
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	nonceSize        = 16
+)
+
+var (
+	ErrHandshake     = errors.New("protocol: handshake failed")
+	ErrUnauthorized  = errors.New("protocol: unauthorized")
+	ErrNotNegotiated = errors.New("protocol: feature not negotiated")
+)
+
+// WithHandshake makes connections start with a handshake, in which the client and the server agree on the
+// highest version they both support and on their common features. If secret is not nil, both prove they know it
+// by answering the challenge of the other with an HMAC-SHA256. Both ends must use it.
+func WithHandshake(secret []byte) Option {
+	return func(o *options) {
+		o.handshake = true
+		o.secret = secret
+	}
+}
+
+// WithVersions sets the versions supported in the handshake, Version2 and Version3 by default.
+func WithVersions(versions ...uint16) Option {
+	return func(o *options) {
+		o.versions = versions
+	}
+}
+
+// WithFeatures sets the features supported in the handshake, AllFeatures by default.
+// FeatureEncryption is only offered with a decryption key.
+func WithFeatures(features uint32) Option {
+	return func(o *options) {
+		o.features = features
+	}
+}
+
+// hello is the payload of handshake packets.
+type hello struct {
+	Versions []uint16 `json:"versions,omitempty"`
+	Version  uint16   `json:"version,omitempty"`
+	Features uint32   `json:"features"`
+	Nonce    []byte   `json:"nonce,omitempty"`
+	MAC      []byte   `json:"mac,omitempty"`
+
+	raw []byte // payload on the wire
+}
+
+// Version returns the version of the packets sent on the connection, negotiated by the handshake.
+func (c *Conn) Version() uint16 {
+	return c.version
+}
+
+// Features returns the features negotiated by the handshake, AllFeatures without handshake.
+func (c *Conn) Features() uint32 {
+	return c.features
+}
+
+// localFeatures returns the features the connection can receive.
+func (c *Conn) localFeatures() uint32 {
+	features := c.opts.features
+	if c.dec.key == nil {
+		features &^= FeatureEncryption
+	}
+	if c.datagram {
+		features &^= FeatureFragmentation
+	}
+	return features
+}
+
+// handshake runs the handshake before the read and write loops start.
+func (c *Conn) handshake(client bool) error {
+	if !c.opts.handshake {
+		return nil
+	}
+
+	c.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	var err error
+	if client {
+		err = c.clientHandshake()
+	} else {
+		err = c.serverHandshake()
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.version < Version3 {
+		// features are carried by version 3 flags
+		c.features = 0
+	}
+	if c.enc.key != nil && c.features&FeatureEncryption == 0 {
+		return fmt.Errorf("%w: the peer does not support encryption", ErrHandshake)
+	}
+	if c.features&FeatureCompression == 0 {
+		c.enc.compression = NoCompression
+	}
+	return nil
+}
+
+func (c *Conn) clientHandshake() error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	req := &hello{Versions: c.opts.versions, Features: c.localFeatures(), Nonce: nonce}
+	if err := c.writeHello(OpHello, req); err != nil {
+		return err
+	}
+
+	ack, err := c.readHello(OpHello)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(c.opts.versions, ack.Version) {
+		return fmt.Errorf("%w: version %d chosen by the server is not supported", ErrHandshake, ack.Version)
+	}
+	if c.opts.secret != nil && !hmac.Equal(ack.MAC, c.mac("server", req, ack)) {
+		return fmt.Errorf("%w: server: %w", ErrHandshake, ErrUnauthorized)
+	}
+
+	var mac []byte
+	if c.opts.secret != nil {
+		mac = c.mac("client", req, ack)
+	}
+	if err := c.writeHello(OpAuth, &hello{MAC: mac}); err != nil {
+		return err
+	}
+
+	c.version = ack.Version
+	c.features = ack.Features & c.localFeatures()
+	return nil
+}
+
+func (c *Conn) serverHandshake() error {
+	req, err := c.readHello(OpHello)
+	if err != nil {
+		return err
+	}
+
+	var version uint16
+	for _, v := range req.Versions {
+		if v > version && slices.Contains(c.opts.versions, v) {
+			version = v
+		}
+	}
+	if version == 0 {
+		err := fmt.Errorf("%w: no common version in %v", ErrHandshake, req.Versions)
+		c.writeHandshakeError(err)
+		return err
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	ack := &hello{Version: version, Features: req.Features & c.localFeatures(), Nonce: nonce}
+	if c.opts.secret != nil {
+		ack.MAC = c.mac("server", req, ack)
+	}
+	if err := c.writeHello(OpHello, ack); err != nil {
+		return err
+	}
+
+	auth, err := c.readHello(OpAuth)
+	if err != nil {
+		return err
+	}
+	if c.opts.secret != nil && !hmac.Equal(auth.MAC, c.mac("client", req, ack)) {
+		err := fmt.Errorf("%w: client: %w", ErrHandshake, ErrUnauthorized)
+		c.writeHandshakeError(err)
+		return err
+	}
+
+	c.version = version
+	c.features = ack.Features
+	return nil
+}
+
+// mac proves the knowledge of the secret, for the challenge of the other side. It covers the hello of the
+// client as sent, and the version, features and nonce chosen by the server, so that they cannot be altered.
+func (c *Conn) mac(side string, req, ack *hello) []byte {
+	data := append([]byte(side), binary.BigEndian.AppendUint32(nil, uint32(len(req.raw)))...)
+	data = append(data, req.raw...)
+	data = binary.BigEndian.AppendUint16(data, ack.Version)
+	data = binary.BigEndian.AppendUint32(data, ack.Features)
+	return zcrypto.HmacSHA256Bytes(append(data, ack.Nonce...), c.opts.secret)
+}
+
+func (c *Conn) writeHello(op uint16, h *hello) error {
+	payload, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	h.raw = payload
+	return c.enc.Encode(NewPacket(op, payload, WithVersion(Version2)))
+}
+
+func (c *Conn) readHello(op uint16) (*hello, error) {
+	var pkt Packet
+	if err := c.read(&pkt); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHandshake, err)
+	}
+	switch pkt.Header.Operation {
+	case op:
+	case OpError:
+		var e Error
+		if err := json.Unmarshal(pkt.Payload, &e); err != nil {
+			return nil, fmt.Errorf("%w: invalid error response", ErrHandshake)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrHandshake, &e)
+	default:
+		return nil, fmt.Errorf("%w: operation %#x, want %#x", ErrHandshake, pkt.Header.Operation, op)
+	}
+
+	h := &hello{raw: pkt.Payload}
+	if err := json.Unmarshal(pkt.Payload, h); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHandshake, err)
+	}
+	return h, nil
+}
+
+// writeHandshakeError tells the client why the handshake failed, before the connection is closed.
+func (c *Conn) writeHandshakeError(err error) {
+	payload, _ := json.Marshal(&Error{Code: CodeHandshake, Message: err.Error()})
+	c.enc.Encode(NewPacket(OpError, payload, WithVersion(Version2)))
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
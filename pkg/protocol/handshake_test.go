@@ -0,0 +1,210 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Lysander66/zephyr/pkg/zcrypto"
+)
+
+// handshakePipe runs the handshake between a client and a server connection.
+func handshakePipe(t *testing.T, clientOpts, serverOpts []Option) (client, server *Conn, clientErr, serverErr error) {
+	t.Helper()
+	a, b := net.Pipe()
+	client = newConn(a, newOptions(clientOpts))
+	server = newConn(b, newOptions(serverOpts))
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		err := server.handshake(false)
+		if err != nil {
+			server.Close()
+		}
+		done <- err
+	}()
+	clientErr = client.handshake(true)
+	if clientErr != nil {
+		client.Close()
+	}
+	return client, server, clientErr, <-done
+}
+
+func TestHandshake(t *testing.T) {
+	secret := []byte("secret")
+	key, _ := zcrypto.RandomKey(32)
+	encrypted := []Option{WithEncoderOptions(WithEncryption(key)), WithDecoderOptions(WithDecryption(key))}
+
+	tests := []struct {
+		name         string
+		clientOpts   []Option
+		serverOpts   []Option
+		wantVersion  uint16
+		wantFeatures uint32
+		wantErr      error
+	}{
+		{
+			name:         "same secret",
+			clientOpts:   []Option{WithHandshake(secret)},
+			serverOpts:   []Option{WithHandshake(secret)},
+			wantVersion:  Version3,
+			wantFeatures: FeatureCompression | FeatureFragmentation,
+		},
+		{
+			name:         "encryption",
+			clientOpts:   append([]Option{WithHandshake(nil)}, encrypted...),
+			serverOpts:   append([]Option{WithHandshake(nil)}, encrypted...),
+			wantVersion:  Version3,
+			wantFeatures: AllFeatures,
+		},
+		{
+			name:         "common features",
+			clientOpts:   []Option{WithHandshake(nil), WithFeatures(FeatureFragmentation)},
+			serverOpts:   []Option{WithHandshake(nil)},
+			wantVersion:  Version3,
+			wantFeatures: FeatureFragmentation,
+		},
+		{
+			name:        "older client",
+			clientOpts:  []Option{WithHandshake(nil), WithVersions(Version2)},
+			serverOpts:  []Option{WithHandshake(nil)},
+			wantVersion: Version2,
+		},
+		{
+			name:       "wrong secret",
+			clientOpts: []Option{WithHandshake([]byte("wrong"))},
+			serverOpts: []Option{WithHandshake(secret)},
+			wantErr:    ErrUnauthorized,
+		},
+		{
+			name:       "no common version",
+			clientOpts: []Option{WithHandshake(nil), WithVersions(Version2)},
+			serverOpts: []Option{WithHandshake(nil), WithVersions(Version3)},
+			wantErr:    ErrHandshake,
+		},
+		{
+			name:       "encryption not supported by the server",
+			clientOpts: append([]Option{WithHandshake(nil)}, encrypted...),
+			serverOpts: []Option{WithHandshake(nil)},
+			wantErr:    ErrHandshake,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server, clientErr, serverErr := handshakePipe(t, tt.clientOpts, tt.serverOpts)
+			if tt.wantErr != nil {
+				if !errors.Is(clientErr, tt.wantErr) {
+					t.Errorf("client err = %v, want %v", clientErr, tt.wantErr)
+				}
+				return
+			}
+			if clientErr != nil || serverErr != nil {
+				t.Fatalf("client err = %v, server err = %v", clientErr, serverErr)
+			}
+			for _, c := range []*Conn{client, server} {
+				if c.Version() != tt.wantVersion || c.Features() != tt.wantFeatures {
+					t.Errorf("version %d, features %#x, want %d, %#x", c.Version(), c.Features(), tt.wantVersion, tt.wantFeatures)
+				}
+			}
+		})
+	}
+}
+
+// forwardHellos copies the packets from src to dst, passing the OpHello payloads to tamper.
+func forwardHellos(dst, src net.Conn, tamper func(h *hello)) {
+	defer dst.Close()
+	dec, enc := NewDecoder(src), NewEncoder(dst)
+	for {
+		var pkt Packet
+		if err := dec.Decode(&pkt); err != nil {
+			return
+		}
+		if pkt.Header.Operation == OpHello {
+			h := &hello{}
+			json.Unmarshal(pkt.Payload, h)
+			tamper(h)
+			pkt.Payload, _ = json.Marshal(h)
+		}
+		if err := enc.Encode(&pkt); err != nil {
+			return
+		}
+	}
+}
+
+func TestHandshake_Tampered(t *testing.T) {
+	tests := []struct {
+		name       string
+		hello, ack func(h *hello)
+	}{
+		{
+			name:  "downgraded versions",
+			hello: func(h *hello) { h.Versions = []uint16{Version2} },
+			ack:   func(h *hello) {},
+		},
+		{
+			name:  "removed features",
+			hello: func(h *hello) {},
+			ack:   func(h *hello) { h.Features = 0 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// client <-> a|b <-> c|d <-> server, with the hellos altered in between
+			a, b := net.Pipe()
+			c, d := net.Pipe()
+			go forwardHellos(c, b, tt.hello)
+			go forwardHellos(b, c, tt.ack)
+
+			opts := newOptions([]Option{WithHandshake([]byte("secret"))})
+			client, server := newConn(a, opts), newConn(d, opts)
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				server.handshake(false)
+				server.Close()
+			}()
+			if err := client.handshake(true); !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("client err = %v, want %v", err, ErrUnauthorized)
+			}
+		})
+	}
+}
+
+func TestHandshake_ServerClient(t *testing.T) {
+	secret := []byte("secret")
+	_, addr := newTestServer(t, WithHandshake(secret))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// an older client gets version 2 responses
+	client := NewClient(addr, WithHandshake(secret), WithVersions(Version2))
+	defer client.Close()
+	resp, err := client.Call(ctx, opEcho, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Version != Version2 {
+		t.Errorf("version = %d, want 2", resp.Header.Version)
+	}
+	conn, _ := client.Conn(ctx)
+	if _, err := conn.OpenStream(ctx, opUpload).Write([]byte("x")); !errors.Is(err, ErrNotNegotiated) {
+		t.Errorf("stream over version 2: err = %v, want ErrNotNegotiated", err)
+	}
+
+	// a client with the wrong secret never connects
+	intruder := NewClient(addr, WithHandshake([]byte("wrong")), WithReconnect(10*time.Millisecond, 10*time.Millisecond))
+	defer intruder.Close()
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := intruder.Call(short, opEcho, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
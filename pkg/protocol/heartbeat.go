@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Reserved operations, handled by connections and never dispatched to handlers.
+const (
+	// OpPing is sent every heartbeat interval, the payload is the send time.
+	OpPing uint16 = 0xFFFF
+	// OpPong answers a ping with its payload.
+	OpPong uint16 = 0xFFFE
+)
+
+const (
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultIdleTimeout       = 90 * time.Second
+	defaultWriteTimeout      = 10 * time.Second
+)
+
+// ErrIdleTimeout closes connections on which nothing was received for the idle timeout.
+var ErrIdleTimeout = errors.New("protocol: idle timeout")
+
+// WithHeartbeat sets the interval of pings, and the idle timeout after which a connection on which nothing
+// was received is dead, 30 and 90 seconds by default. A zero interval disables pings, a zero timeout disables
+// the detection of dead connections.
+func WithHeartbeat(interval, idleTimeout time.Duration) Option {
+	return func(o *options) {
+		o.heartbeatInterval = interval
+		o.idleTimeout = idleTimeout
+	}
+}
+
+// WithWriteTimeout sets the timeout of writing a packet, 10 seconds by default.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithOnDead sets a hook called when a connection is closed after the idle timeout.
+func WithOnDead(fn func(c *Conn)) Option {
+	return func(o *options) {
+		o.onDead = fn
+	}
+}
+
+func newPing() *Packet {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	return NewPacket(OpPing, payload)
+}
+
+// heartbeat handles ping and pong packets, and reports whether pkt was one.
+func (c *Conn) heartbeat(pkt *Packet) bool {
+	switch pkt.Header.Operation {
+	case OpPing:
+		pong := NewPacket(OpPong, pkt.Payload, replyTo(pkt))
+		if err := c.Send(pong); err != nil && !errors.Is(err, ErrConnClosed) {
+			// the peer pings again
+			slog.Debug("protocol: pong", "err", err)
+		}
+		return true
+	case OpPong:
+		if len(pkt.Payload) == 8 {
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(pkt.Payload)))
+			c.rtt.Store(int64(time.Since(sent)))
+		}
+		return true
+	}
+	return false
+}
+
+// RTT returns the round-trip time measured by the last ping, 0 until a pong is received.
+func (c *Conn) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	connected := make(chan *Conn, 1)
+	_, addr := newTestServer(t,
+		WithHeartbeat(10*time.Millisecond, time.Second),
+		WithOnConnect(func(c *Conn) { connected <- c }),
+	)
+	client := NewClient(addr, WithHeartbeat(10*time.Millisecond, time.Second))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := <-connected
+	for conn.RTT() == 0 || sc.RTT() == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("no RTT measured")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// pings keep the connection alive past the idle timeout
+	time.Sleep(50 * time.Millisecond)
+	if _, err := client.Call(ctx, opEcho, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	dead := make(chan *Conn, 1)
+	disconnected := make(chan error, 1)
+	_, addr := newTestServer(t,
+		WithHeartbeat(0, 50*time.Millisecond),
+		WithOnDead(func(c *Conn) { dead <- c }),
+		WithOnDisconnect(func(c *Conn, err error) { disconnected <- err }),
+	)
+
+	// a peer that never sends anything, not even pongs
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	select {
+	case <-dead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not detected dead")
+	}
+	if err := <-disconnected; !errors.Is(err, ErrIdleTimeout) {
+		t.Errorf("err = %v, want ErrIdleTimeout", err)
+	}
+}
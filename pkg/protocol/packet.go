@@ -2,14 +2,21 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
-	"time"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
 )
 
 /*
 Binary Protocol Format
 
-The protocol uses a fixed-length header with variable-length payload:
+The protocol uses a fixed-length header with variable-length payload.
+
+Version 2 carries the payload length, so that packets can be read one after another from a stream.
+Version 3 adds flags for the compression, encryption and checksum of the payload:
 
  0                   1                   2                   3
  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
@@ -18,34 +25,83 @@ The protocol uses a fixed-length header with variable-length payload:
 +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 |                       SequenceID (4 bytes)                    |
 +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                     PayloadLength (4 bytes)                   |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                         Flags (4 bytes)                       |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                        Checksum (4 bytes)                     |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 |                           Payload                             |
 |                          (variable)                           |
 +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 
 Header Fields:
-- Version: Protocol version, currently 1 (2 bytes)
+- Version: Protocol version (2 bytes)
 - Operation: Operation type (2 bytes)
 - SequenceID: Sequence ID for message tracking (4 bytes)
+- PayloadLength: Length of the payload on the wire in bytes (4 bytes), version 2 and later
+- Flags: FlagChecksum, FlagEncrypted, FlagReply and the Compression in bits 8-11 (4 bytes), version 3 only
+- Checksum: CRC32C of the payload on the wire if FlagChecksum is set (4 bytes), version 3 only
 
-Total header size: 8 bytes
+Total header size: 20 bytes, 12 bytes for version 2, 8 bytes for version 1
 All fields are in network byte order
+
+Version 1 has no PayloadLength: the payload is the rest of the data,
+so version 1 packets can only be decoded from whole buffers, such as datagrams.
+
+The payload is compressed, then encrypted, then checksummed. The encryption authenticates
+the Version, Operation and SequenceID too.
 */
 
 const (
-	Version uint16 = 1
+	Version1 uint16 = 1
+	Version2 uint16 = 2
+	Version3 uint16 = 3
+
+	// Version is the version of new packets
+	Version = Version3
+)
+
+const (
+	HeaderSizeV1 = 8
+	HeaderSizeV2 = 12
+	HeaderSizeV3 = 20
+
+	// DefaultMaxPayloadSize is the max payload size accepted by decoders, unless set with WithMaxPayloadSize
+	DefaultMaxPayloadSize = 4 << 20
 )
 
 var (
-	ErrInvalidLength  = errors.New("invalid packet length")
-	ErrInvalidPayload = errors.New("invalid payload")
-	ErrInvalidVersion = errors.New("invalid packet version")
+	ErrInvalidLength   = errors.New("invalid packet length")
+	ErrInvalidPayload  = errors.New("invalid payload")
+	ErrInvalidVersion  = errors.New("invalid packet version")
+	ErrPayloadTooLarge = errors.New("payload too large")
 )
 
 // FixedLengthHeader represents the fixed-length header of a packet
 type FixedLengthHeader struct {
-	Version    uint16 // Protocol version, currently 1
+	Version    uint16 // Protocol version
 	Operation  uint16 // Operation type
 	SequenceID uint32 // Sequence ID for message tracking
+	// PayloadLength is written by the encoder from the payload, version 2 and later
+	PayloadLength uint32
+	// Flags and Checksum are written by the encoder from its options, version 3 only.
+	// The encoded packet keeps its header, decoded packets hold the values on the wire.
+	Flags    uint32
+	Checksum uint32
+}
+
+// headerSize returns the size of the header of version, 0 for unknown versions.
+func headerSize(version uint16) int {
+	switch version {
+	case Version1:
+		return HeaderSizeV1
+	case Version2:
+		return HeaderSizeV2
+	case Version3:
+		return HeaderSizeV3
+	}
+	return 0
 }
 
 // Packet represents a complete data packet
@@ -55,12 +111,24 @@ type Packet struct {
 }
 
 func (p *Packet) validate() error {
-	if p.Header.Version != Version {
+	if headerSize(p.Header.Version) == 0 {
 		return ErrInvalidVersion
 	}
 	return nil
 }
 
+var sequenceID atomic.Uint32
+
+// NextSequenceID returns the next sequence ID of the process, used by NewPacket unless WithSequenceID is given.
+// IDs are monotonic, skipping 0 when they wrap around.
+func NextSequenceID() uint32 {
+	for {
+		if id := sequenceID.Add(1); id != 0 {
+			return id
+		}
+	}
+}
+
 type PacketOption func(*Packet)
 
 func WithSequenceID(sequenceID uint32) PacketOption {
@@ -69,6 +137,22 @@ func WithSequenceID(sequenceID uint32) PacketOption {
 	}
 }
 
+// WithVersion sets the version of the packet, e.g. Version1 for peers that only know version 1.
+func WithVersion(version uint16) PacketOption {
+	return func(p *Packet) {
+		p.Header.Version = version
+	}
+}
+
+// replyTo makes the packet a response to req, with its sequence ID and version.
+func replyTo(req *Packet) PacketOption {
+	return func(p *Packet) {
+		p.Header.SequenceID = req.Header.SequenceID
+		p.Header.Version = req.Header.Version
+		p.Header.Flags |= FlagReply
+	}
+}
+
 func NewPacket(operation uint16, payload []byte, opts ...PacketOption) *Packet {
 	pkt := &Packet{
 		Header: FixedLengthHeader{
@@ -83,7 +167,7 @@ func NewPacket(operation uint16, payload []byte, opts ...PacketOption) *Packet {
 	}
 
 	if pkt.Header.SequenceID == 0 {
-		pkt.Header.SequenceID = uint32(time.Now().UnixNano())
+		pkt.Header.SequenceID = NextSequenceID()
 	}
 
 	return pkt
@@ -94,25 +178,95 @@ func Pack(pkt *Packet) ([]byte, error) {
 	if err := pkt.validate(); err != nil {
 		return nil, err
 	}
+	return AppendPack(make([]byte, 0, headerSize(pkt.Header.Version)+len(pkt.Payload)), pkt)
+}
 
-	buf := bytes.NewBuffer(make([]byte, 0, 4096))
-	encoder := NewEncoder(buf)
-	if err := encoder.Encode(pkt); err != nil {
-		return nil, err
+// AppendPack appends the encoded packet to dst, e.g. the B of a PacketBuffer, and returns the extended buffer.
+func AppendPack(dst []byte, pkt *Packet) ([]byte, error) {
+	var e Encoder
+	return e.appendPacket(dst, pkt)
+}
+
+// DecodeFrom decodes the packet at the start of data, and returns the number of bytes it used.
+// It returns io.ErrUnexpectedEOF if data does not hold a whole packet yet. The payload of a version 1
+// packet is the rest of data.
+//
+// The payload is not copied: it references data. Payloads transformed by the flags of the header
+// are left as on the wire, they are decoded by a Decoder.
+func (p *Packet) DecodeFrom(data []byte) (int, error) {
+	if len(data) < HeaderSizeV1 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	version := binary.BigEndian.Uint16(data)
+	size := headerSize(version)
+	if size == 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidVersion, version)
+	}
+	if len(data) < size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	unmarshalHeader(data[:size], &p.Header)
+
+	if version == Version1 {
+		p.Payload = data[size:]
+		return len(data), nil
 	}
+	end := size + int(p.Header.PayloadLength)
+	if len(data) < end {
+		return 0, io.ErrUnexpectedEOF
+	}
+	p.Payload = data[size:end:end]
+	return end, nil
+}
+
+const maxPooledBufferSize = 64 << 10
 
-	return buf.Bytes(), nil
+var packetBufferPool = sync.Pool{
+	New: func() any {
+		return &PacketBuffer{B: make([]byte, 0, 1024)}
+	},
 }
 
-// Unpack decodes binary data to packet
+// PacketBuffer is a reusable buffer for encoding packets.
+//
+//	b := protocol.GetPacketBuffer()
+//	defer b.Release()
+//	b.B, err = protocol.AppendPack(b.B, pkt)
+type PacketBuffer struct {
+	B []byte
+}
+
+// GetPacketBuffer returns an empty buffer from the pool.
+func GetPacketBuffer() *PacketBuffer {
+	return packetBufferPool.Get().(*PacketBuffer)
+}
+
+// Release puts the buffer back in the pool. It must not be used afterwards.
+func (b *PacketBuffer) Release() {
+	if cap(b.B) > maxPooledBufferSize {
+		// let large buffers be collected
+		return
+	}
+	b.B = b.B[:0]
+	packetBufferPool.Put(b)
+}
+
+// Unpack decodes binary data to packet. The data must hold exactly one packet, of any version.
 func Unpack(data []byte) (*Packet, error) {
 	buf := bytes.NewReader(data)
-	decoder := NewDecoder(buf)
+	decoder := NewDecoder(buf, WithMaxPayloadSize(len(data)))
 
 	pkt := &Packet{}
 	if err := decoder.Decode(pkt); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrInvalidLength
+		}
 		return nil, err
 	}
+	if buf.Len() > 0 {
+		// trailing bytes after the payload of a version 2 or 3 packet
+		return nil, ErrInvalidLength
+	}
 
 	if err := pkt.validate(); err != nil {
 		return nil, err
@@ -0,0 +1,292 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPackUnpack(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkt     *Packet
+		wantLen int
+	}{
+		{"v3", NewPacket(1, []byte("hello"), WithSequenceID(6)), HeaderSizeV3 + 5},
+		{"v2", NewPacket(1, []byte("hello"), WithSequenceID(7), WithVersion(Version2)), HeaderSizeV2 + 5},
+		{"v2 empty payload", NewPacket(2, nil, WithSequenceID(8), WithVersion(Version2)), HeaderSizeV2},
+		{"v1", NewPacket(3, []byte("hello"), WithSequenceID(9), WithVersion(Version1)), HeaderSizeV1 + 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Pack(tt.pkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != tt.wantLen {
+				t.Errorf("len = %d, want %d", len(data), tt.wantLen)
+			}
+
+			if tt.pkt.Header.PayloadLength != 0 {
+				t.Errorf("packed packet payload length = %d, want it left as is", tt.pkt.Header.PayloadLength)
+			}
+
+			got, err := Unpack(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := wireHeader(tt.pkt); got.Header != want || !bytes.Equal(got.Payload, tt.pkt.Payload) {
+				t.Errorf("got %+v %q, want %+v %q", got.Header, got.Payload, want, tt.pkt.Payload)
+			}
+		})
+	}
+}
+
+// wireHeader returns the header of pkt as encoded without transforms.
+func wireHeader(pkt *Packet) FixedLengthHeader {
+	h := pkt.Header
+	if h.Version >= Version2 {
+		h.PayloadLength = uint32(len(pkt.Payload))
+	}
+	return h
+}
+
+func TestUnpack_Invalid(t *testing.T) {
+	v2, _ := Pack(NewPacket(1, []byte("hello"), WithSequenceID(1)))
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"short header", v2[:5], ErrInvalidLength},
+		{"short v2 header", v2[:10], ErrInvalidLength},
+		{"short payload", v2[:len(v2)-1], ErrInvalidLength},
+		{"trailing bytes", append(bytes.Clone(v2), 0), ErrInvalidLength},
+		{"unknown version", append([]byte{0, 9}, v2[2:]...), ErrInvalidVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unpack(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecoder_Stream(t *testing.T) {
+	client, server := net.Pipe()
+	packets := []*Packet{
+		NewPacket(1, []byte("first"), WithSequenceID(1)),
+		NewPacket(2, nil, WithSequenceID(2)),
+		NewPacket(3, bytes.Repeat([]byte{'x'}, 10000), WithSequenceID(3)),
+	}
+	go func() {
+		enc := NewEncoder(client)
+		for _, pkt := range packets {
+			if err := enc.Encode(pkt); err != nil {
+				t.Error(err)
+			}
+		}
+		client.Close()
+	}()
+
+	dec := NewDecoder(server)
+	for _, want := range packets {
+		var pkt Packet
+		if err := dec.Decode(&pkt); err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Header != wireHeader(want) || !bytes.Equal(pkt.Payload, want.Payload) {
+			t.Errorf("got %+v, want %+v", pkt.Header, wireHeader(want))
+		}
+	}
+	var pkt Packet
+	if err := dec.Decode(&pkt); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoder_MaxPayloadSize(t *testing.T) {
+	data, _ := Pack(NewPacket(1, make([]byte, 100)))
+
+	dec := NewDecoder(bytes.NewReader(data), WithMaxPayloadSize(99))
+	var pkt Packet
+	if err := dec.Decode(&pkt); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("err = %v, want ErrPayloadTooLarge", err)
+	}
+}
+
+func TestAppendPackDecodeFrom(t *testing.T) {
+	packets := []*Packet{
+		NewPacket(1, []byte("first"), WithSequenceID(1)),
+		NewPacket(2, nil, WithSequenceID(2), WithVersion(Version2)),
+		NewPacket(3, bytes.Repeat([]byte{'x'}, 1000), WithSequenceID(3)),
+	}
+
+	var data []byte
+	for _, pkt := range packets {
+		var err error
+		if data, err = AppendPack(data, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range packets {
+		var pkt Packet
+		if _, err := pkt.DecodeFrom(data[:HeaderSizeV1+1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("partial packet: err = %v, want io.ErrUnexpectedEOF", err)
+		}
+		n, err := pkt.DecodeFrom(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Header != wireHeader(want) || !bytes.Equal(pkt.Payload, want.Payload) {
+			t.Errorf("got %+v, want %+v", pkt.Header, wireHeader(want))
+		}
+		data = data[n:]
+	}
+	if len(data) != 0 {
+		t.Errorf("%d bytes left", len(data))
+	}
+}
+
+func TestHeaderMarshalTo(t *testing.T) {
+	h := FixedLengthHeader{Version: Version3, Operation: 1, SequenceID: 2, PayloadLength: 3, Flags: 4, Checksum: 5}
+	buf := make([]byte, HeaderSizeV3)
+	if n, err := h.MarshalTo(buf); err != nil || n != HeaderSizeV3 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+	var got FixedLengthHeader
+	unmarshalHeader(buf, &got)
+	if got != h {
+		t.Errorf("got %+v, want %+v", got, h)
+	}
+
+	if _, err := h.MarshalTo(buf[:HeaderSizeV2]); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("err = %v, want io.ErrShortBuffer", err)
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	pkt := NewPacket(1, make([]byte, 256))
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := Pack(pkt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendPack(b *testing.B) {
+	pkt := NewPacket(1, make([]byte, 256))
+	b.ReportAllocs()
+	for range b.N {
+		buf := GetPacketBuffer()
+		var err error
+		if buf.B, err = AppendPack(buf.B, pkt); err != nil {
+			b.Fatal(err)
+		}
+		buf.Release()
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	pkt := NewPacket(1, make([]byte, 256))
+	enc := NewEncoder(io.Discard)
+	b.ReportAllocs()
+	for range b.N {
+		if err := enc.Encode(pkt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	data, _ := Pack(NewPacket(1, make([]byte, 256)))
+	r := bytes.NewReader(data)
+	dec := NewDecoder(r)
+	b.ReportAllocs()
+	for range b.N {
+		r.Reset(data)
+		var pkt Packet
+		if err := dec.Decode(&pkt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeFrom(b *testing.B) {
+	data, _ := Pack(NewPacket(1, make([]byte, 256)))
+	b.ReportAllocs()
+	for range b.N {
+		var pkt Packet
+		if _, err := pkt.DecodeFrom(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func FuzzUnpack(f *testing.F) {
+	for _, pkt := range []*Packet{
+		NewPacket(1, []byte("hello"), WithSequenceID(1)),
+		NewPacket(2, nil, WithSequenceID(2), WithVersion(Version2)),
+		NewPacket(3, []byte("hello"), WithSequenceID(3), WithVersion(Version1)),
+	} {
+		data, _ := Pack(pkt)
+		f.Add(data)
+	}
+	var compressed bytes.Buffer
+	NewEncoder(&compressed, WithCompression(Snappy, 0), WithChecksum()).Encode(NewPacket(4, bytes.Repeat([]byte("ab"), 50)))
+	f.Add(compressed.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := Unpack(data)
+		if err != nil {
+			return
+		}
+
+		// packets without transformed payloads are encoded back to the same bytes,
+		// unless they carry a checksum the flags ignore
+		if pkt.Header.Flags&^packetFlags != 0 || pkt.Header.Checksum != 0 {
+			return
+		}
+		got, err := Pack(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Pack(Unpack(%x)) = %x", data, got)
+		}
+
+		var from Packet
+		n, err := from.DecodeFrom(data)
+		if err != nil || n != len(data) || from.Header != pkt.Header || !bytes.Equal(from.Payload, pkt.Payload) {
+			t.Errorf("DecodeFrom(%x) = %d, %v, %+v", data, n, err, from.Header)
+		}
+	})
+}
+
+func FuzzDecoder(f *testing.F) {
+	key := bytes.Repeat([]byte{1}, 16)
+	var stream bytes.Buffer
+	NewEncoder(&stream).Encode(NewPacket(1, []byte("first")))
+	NewEncoder(&stream, WithCompression(Zstd, 0), WithEncryption(key), WithChecksum()).Encode(NewPacket(2, bytes.Repeat([]byte("ab"), 50)))
+	NewEncoder(&stream, WithCompression(Gzip, 0)).Encode(NewPacket(3, bytes.Repeat([]byte("cd"), 50)))
+	f.Add(stream.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := NewDecoder(bytes.NewReader(data), WithMaxPayloadSize(1<<16), WithDecryption(key))
+		for {
+			var pkt Packet
+			if err := dec.Decode(&pkt); err != nil {
+				return
+			}
+			if len(pkt.Payload) > 1<<16 {
+				t.Fatalf("payload of %d bytes beyond the max size", len(pkt.Payload))
+			}
+		}
+	})
+}
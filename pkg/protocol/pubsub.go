@@ -0,0 +1,395 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reserved operations of publish/subscribe.
+const (
+	// OpSubscribe subscribes to the topics matching the filter in the payload
+	OpSubscribe uint16 = 0xFFFA
+	// OpUnsubscribe removes the subscription of the filter in the payload
+	OpUnsubscribe uint16 = 0xFFF9
+	// OpPublish carries a message, from a publisher to the broker and from the broker to subscribers
+	OpPublish uint16 = 0xFFF8
+)
+
+const defaultSubscriberQueueSize = 256
+
+var ErrInvalidTopic = errors.New("protocol: invalid topic")
+
+// SlowConsumerPolicy decides what happens to messages for a subscriber whose queue is full.
+type SlowConsumerPolicy int
+
+const (
+	// DropOldest drops the oldest queued message of the subscriber
+	DropOldest SlowConsumerPolicy = iota
+	// Disconnect closes the connection of the subscriber
+	Disconnect
+)
+
+// Message is a message published to a topic.
+type Message struct {
+	Topic string
+	Data  []byte
+	// Retained is set on the last message of a topic, sent to new subscribers
+	Retained bool
+}
+
+// MessageHandler handles the messages of a subscription.
+type MessageHandler func(msg *Message)
+
+// message payload: flags (1 byte), topic length (2 bytes), topic, data
+const messageRetained byte = 1 << 0
+
+func encodeMessage(msg *Message) []byte {
+	buf := make([]byte, 0, 3+len(msg.Topic)+len(msg.Data))
+	var flags byte
+	if msg.Retained {
+		flags |= messageRetained
+	}
+	buf = append(buf, flags)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(msg.Topic)))
+	buf = append(buf, msg.Topic...)
+	return append(buf, msg.Data...)
+}
+
+func decodeMessage(payload []byte) (*Message, error) {
+	if len(payload) < 3 {
+		return nil, ErrInvalidPayload
+	}
+	n := int(binary.BigEndian.Uint16(payload[1:]))
+	if len(payload) < 3+n {
+		return nil, ErrInvalidPayload
+	}
+	return &Message{
+		Topic:    string(payload[3 : 3+n]),
+		Data:     payload[3+n:],
+		Retained: payload[0]&messageRetained != 0,
+	}, nil
+}
+
+// ValidTopic reports whether topic can be published to: levels separated by '/', without wildcards.
+func ValidTopic(topic string) bool {
+	return topic != "" && len(topic) <= math.MaxUint16 && !strings.ContainsAny(topic, "+#")
+}
+
+// ValidFilter reports whether filter is a valid subscription filter. The '+' level matches any one level,
+// and a last '#' level matches any number of levels, e.g. "relay/+/state" or "relay/#".
+func ValidFilter(filter string) bool {
+	if filter == "" || len(filter) > math.MaxUint16 {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i == len(levels)-1:
+		case level == "+":
+		case strings.ContainsAny(level, "+#"):
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTopic reports whether topic matches the subscription filter.
+func MatchTopic(filter, topic string) bool {
+	for {
+		f, fRest, fMore := strings.Cut(filter, "/")
+		t, tRest, tMore := strings.Cut(topic, "/")
+		switch {
+		case f == "#":
+			return true
+		case f != "+" && f != t:
+			return false
+		case !fMore || !tMore:
+			return fMore == tMore
+		}
+		filter, topic = fRest, tRest
+	}
+}
+
+// Broker fans out the messages published by the clients of a server to the subscribed clients.
+//
+//	s := protocol.NewServer()
+//	broker := protocol.NewBroker(s, protocol.WithSlowConsumerPolicy(protocol.Disconnect))
+//	broker.Publish("relay/1/state", []byte("up"), true)
+type Broker struct {
+	queueSize int
+	policy    SlowConsumerPolicy
+
+	mu       sync.RWMutex
+	subs     map[*Conn]*subscriber
+	retained map[string]*Message
+}
+
+type BrokerOption func(*Broker)
+
+// WithSubscriberQueue sets the number of messages queued for each subscriber, 256 by default.
+func WithSubscriberQueue(size int) BrokerOption {
+	return func(b *Broker) {
+		b.queueSize = size
+	}
+}
+
+// WithSlowConsumerPolicy sets what happens when the queue of a subscriber is full, DropOldest by default.
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) BrokerOption {
+	return func(b *Broker) {
+		b.policy = policy
+	}
+}
+
+// NewBroker returns a Broker handling the publish/subscribe operations of s.
+func NewBroker(s *Server, opts ...BrokerOption) *Broker {
+	b := &Broker{
+		queueSize: defaultSubscriberQueueSize,
+		subs:      make(map[*Conn]*subscriber),
+		retained:  make(map[string]*Message),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	s.Handle(OpSubscribe, b.handleSubscribe)
+	s.Handle(OpUnsubscribe, b.handleUnsubscribe)
+	s.Handle(OpPublish, func(ctx context.Context, c *Conn, pkt *Packet) {
+		msg, err := decodeMessage(pkt.Payload)
+		if err != nil || !ValidTopic(msg.Topic) {
+			slog.Debug("protocol: invalid message", "remote", c.RemoteAddr(), "err", err)
+			return
+		}
+		b.Publish(msg.Topic, msg.Data, msg.Retained)
+	})
+	return b
+}
+
+// Publish sends data to the subscribers of topic. If retain is true, the message is kept as the last message
+// of the topic for future subscribers, and an empty retained message clears it.
+func (b *Broker) Publish(topic string, data []byte, retain bool) error {
+	if !ValidTopic(topic) {
+		return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+	}
+
+	if retain {
+		b.mu.Lock()
+		if len(data) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = &Message{Topic: topic, Data: bytes.Clone(data), Retained: true}
+		}
+		b.mu.Unlock()
+	}
+
+	payload := encodeMessage(&Message{Topic: topic, Data: data})
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subs {
+		if sub.matches(topic) {
+			sub.enqueue(NewPacket(OpPublish, payload))
+		}
+	}
+	return nil
+}
+
+// Subscribers returns the number of subscribed connections.
+func (b *Broker) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs)
+}
+
+func (b *Broker) handleSubscribe(ctx context.Context, c *Conn, pkt *Packet) {
+	filter := string(pkt.Payload)
+	if !ValidFilter(filter) {
+		c.sendError(pkt, &Error{Code: CodeInvalidPayload, Message: fmt.Sprintf("invalid filter %q", filter)})
+		return
+	}
+
+	b.mu.Lock()
+	sub, ok := b.subs[c]
+	if !ok {
+		sub = &subscriber{b: b, c: c, filters: make(map[string]struct{}), queue: make(chan *Packet, b.queueSize)}
+		b.subs[c] = sub
+		go sub.run()
+	}
+	sub.mu.Lock()
+	_, renewed := sub.filters[filter]
+	sub.filters[filter] = struct{}{}
+	sub.mu.Unlock()
+
+	// retained messages are only sent to new subscriptions
+	var retained []*Message
+	for topic, msg := range b.retained {
+		if !renewed && MatchTopic(filter, topic) {
+			retained = append(retained, msg)
+		}
+	}
+	b.mu.Unlock()
+
+	c.Reply(pkt, nil)
+	for _, msg := range retained {
+		sub.enqueue(NewPacket(OpPublish, encodeMessage(msg)))
+	}
+}
+
+func (b *Broker) handleUnsubscribe(ctx context.Context, c *Conn, pkt *Packet) {
+	b.mu.RLock()
+	sub, ok := b.subs[c]
+	b.mu.RUnlock()
+	if ok {
+		sub.mu.Lock()
+		delete(sub.filters, string(pkt.Payload))
+		sub.mu.Unlock()
+	}
+	c.Reply(pkt, nil)
+}
+
+// subscriber queues the messages of a connection, sent by its own goroutine.
+type subscriber struct {
+	b *Broker
+	c *Conn
+
+	mu      sync.Mutex
+	filters map[string]struct{}
+
+	queue chan *Packet
+}
+
+func (s *subscriber) matches(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for filter := range s.filters {
+		if MatchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue queues pkt, applying the slow consumer policy if the queue is full.
+func (s *subscriber) enqueue(pkt *Packet) {
+	for {
+		select {
+		case s.queue <- pkt:
+			return
+		default:
+		}
+
+		if s.b.policy == Disconnect {
+			slog.Warn("protocol: slow consumer disconnected", "remote", s.c.RemoteAddr())
+			s.c.Close()
+			return
+		}
+		select {
+		case <-s.queue:
+		default:
+		}
+	}
+}
+
+func (s *subscriber) run() {
+	defer func() {
+		s.b.mu.Lock()
+		delete(s.b.subs, s.c)
+		s.b.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case pkt := <-s.queue:
+			if err := s.c.SendContext(s.c.Context(), pkt); err != nil {
+				return
+			}
+		case <-s.c.Context().Done():
+			return
+		}
+	}
+}
+
+// Subscribe subscribes to the topics matching filter, see ValidFilter, and calls fn with their messages.
+// Subscriptions are renewed when the client reconnects.
+func (c *Client) Subscribe(ctx context.Context, filter string, fn MessageHandler) error {
+	if !ValidFilter(filter) {
+		return fmt.Errorf("%w: %q", ErrInvalidTopic, filter)
+	}
+
+	c.mu.Lock()
+	if c.subs == nil {
+		c.subs = make(map[string]MessageHandler)
+		c.Handle(OpPublish, c.handleMessage)
+	}
+	c.subs[filter] = fn
+	c.mu.Unlock()
+
+	_, err := c.Call(ctx, OpSubscribe, []byte(filter))
+	return err
+}
+
+// Unsubscribe removes the subscription of filter.
+func (c *Client) Unsubscribe(ctx context.Context, filter string) error {
+	c.mu.Lock()
+	delete(c.subs, filter)
+	c.mu.Unlock()
+
+	_, err := c.Call(ctx, OpUnsubscribe, []byte(filter))
+	return err
+}
+
+// Publish publishes data to topic. See Broker.Publish for retain.
+func (c *Client) Publish(ctx context.Context, topic string, data []byte, retain bool) error {
+	if !ValidTopic(topic) {
+		return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+	}
+	return c.Send(ctx, NewPacket(OpPublish, encodeMessage(&Message{Topic: topic, Data: data, Retained: retain})))
+}
+
+func (c *Client) handleMessage(ctx context.Context, conn *Conn, pkt *Packet) {
+	msg, err := decodeMessage(pkt.Payload)
+	if err != nil {
+		slog.Debug("protocol: invalid message", "err", err)
+		return
+	}
+
+	c.mu.Lock()
+	var handlers []MessageHandler
+	for filter, fn := range c.subs {
+		if MatchTopic(filter, msg.Topic) {
+			handlers = append(handlers, fn)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(msg)
+	}
+}
+
+// resubscribe renews the subscriptions on a new connection.
+func (c *Client) resubscribe() {
+	c.mu.Lock()
+	filters := make([]string, 0, len(c.subs))
+	for filter := range c.subs {
+		filters = append(filters, filter)
+	}
+	c.mu.Unlock()
+
+	for _, filter := range filters {
+		ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+		if _, err := c.Call(ctx, OpSubscribe, []byte(filter)); err != nil {
+			slog.Warn("protocol: resubscribe", "filter", filter, "err", err)
+		}
+		cancel()
+	}
+}
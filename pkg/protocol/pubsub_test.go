@@ -0,0 +1,266 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"relay/1/state", "relay/1/state", true},
+		{"relay/1/state", "relay/2/state", false},
+		{"relay/+/state", "relay/2/state", true},
+		{"relay/+/state", "relay/2/load", false},
+		{"relay/+", "relay/2/state", false},
+		{"relay/+/+", "relay/2", false},
+		{"relay/#", "relay/2/state", true},
+		{"relay/#", "relay", false},
+		{"#", "relay/2/state", true},
+		{"+/#", "relay/2", true},
+		{"relay", "relay/2", false},
+		{"relay/2", "relay", false},
+	}
+	for _, tt := range tests {
+		if got := MatchTopic(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestValidFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"relay/1/state", true},
+		{"relay/+/state", true},
+		{"relay/#", true},
+		{"#", true},
+		{"", false},
+		{"relay/#/state", false},
+		{"relay/a+", false},
+		{"relay#", false},
+	}
+	for _, tt := range tests {
+		if got := ValidFilter(tt.filter); got != tt.want {
+			t.Errorf("ValidFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func receiveMessage(t *testing.T, ch <-chan *Message) *Message {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func subscribe(t *testing.T, client *Client, filter string) <-chan *Message {
+	t.Helper()
+	ch := make(chan *Message, 16)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := client.Subscribe(ctx, filter, func(msg *Message) {
+		ch <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func TestPubSub(t *testing.T) {
+	s, addr := newTestServer(t)
+	broker := NewBroker(s)
+
+	a := NewClient(addr)
+	defer a.Close()
+	b := NewClient(addr)
+	defer b.Close()
+	states := subscribe(t, a, "relay/+/state")
+	all := subscribe(t, b, "relay/#")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Publish(ctx, "relay/1/state", []byte("up"), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range []<-chan *Message{states, all} {
+		if msg := receiveMessage(t, ch); msg.Topic != "relay/1/state" || string(msg.Data) != "up" || msg.Retained {
+			t.Errorf("got %+v", msg)
+		}
+	}
+
+	if err := broker.Publish("relay/1/load", []byte("0.5"), false); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receiveMessage(t, all); msg.Topic != "relay/1/load" {
+		t.Errorf("got %+v", msg)
+	}
+
+	if err := a.Unsubscribe(ctx, "relay/+/state"); err != nil {
+		t.Fatal(err)
+	}
+	broker.Publish("relay/2/state", []byte("down"), false)
+	if msg := receiveMessage(t, all); msg.Topic != "relay/2/state" {
+		t.Errorf("got %+v", msg)
+	}
+	select {
+	case msg := <-states:
+		t.Errorf("unsubscribed, got %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if n := broker.Subscribers(); n != 2 {
+		t.Errorf("subscribers = %d, want 2", n)
+	}
+	if err := broker.Publish("relay/+", nil, false); !errors.Is(err, ErrInvalidTopic) {
+		t.Errorf("publish to a filter: %v, want %v", err, ErrInvalidTopic)
+	}
+	var e *Error
+	if _, err := b.Call(ctx, OpSubscribe, []byte("relay/#/state")); !errors.As(err, &e) || e.Code != CodeInvalidPayload {
+		t.Errorf("subscribe to an invalid filter: %v", err)
+	}
+}
+
+func TestPubSubRetained(t *testing.T) {
+	s, addr := newTestServer(t)
+	broker := NewBroker(s)
+	broker.Publish("relay/1/state", []byte("up"), true)
+	broker.Publish("relay/2/state", []byte("up"), true)
+	broker.Publish("relay/2/state", nil, true)
+
+	client := NewClient(addr)
+	defer client.Close()
+	ch := subscribe(t, client, "relay/+/state")
+	if msg := receiveMessage(t, ch); msg.Topic != "relay/1/state" || string(msg.Data) != "up" || !msg.Retained {
+		t.Errorf("got %+v", msg)
+	}
+
+	broker.Publish("relay/1/state", []byte("down"), true)
+	if msg := receiveMessage(t, ch); string(msg.Data) != "down" || msg.Retained {
+		t.Errorf("got %+v", msg)
+	}
+	select {
+	case msg := <-ch:
+		t.Errorf("cleared retained message, got %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPubSubResubscribe(t *testing.T) {
+	s, addr := newTestServer(t)
+	broker := NewBroker(s)
+
+	client := NewClient(addr, WithReconnect(10*time.Millisecond, 50*time.Millisecond))
+	defer client.Close()
+	ch := subscribe(t, client, "relay/#")
+
+	for _, c := range s.Conns() {
+		c.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		broker.Publish("relay/1/state", []byte("up"), false)
+		select {
+		case msg := <-ch:
+			if msg.Topic != "relay/1/state" {
+				t.Errorf("got %+v", msg)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not resubscribed")
+		}
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	const n = 256
+	data := make([]byte, 64<<10)
+
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+	}{
+		{"drop oldest", DropOldest},
+		{"disconnect", Disconnect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := newTestServer(t, WithQueueSize(4))
+			broker := NewBroker(s, WithSubscriberQueue(4), WithSlowConsumerPolicy(tt.policy))
+
+			disconnected := make(chan struct{}, 1)
+			client := NewClient(addr, WithOnDisconnect(func(c *Conn, err error) {
+				select {
+				case disconnected <- struct{}{}:
+				default:
+				}
+			}))
+			defer client.Close()
+
+			// the client does not read until released
+			release := make(chan struct{})
+			received := make(chan uint32, n)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := client.Subscribe(ctx, "bulk", func(msg *Message) {
+				<-release
+				received <- binary.BigEndian.Uint32(msg.Data)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range uint32(n) {
+				binary.BigEndian.PutUint32(data, i)
+				broker.Publish("bulk", data, false)
+			}
+			close(release)
+
+			if tt.policy == Disconnect {
+				select {
+				case <-disconnected:
+				case <-time.After(5 * time.Second):
+					t.Fatal("slow consumer not disconnected")
+				}
+				return
+			}
+
+			count := 0
+			for {
+				select {
+				case i := <-received:
+					count++
+					if i < n-1 {
+						continue
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("last message not received")
+				}
+				break
+			}
+			if count == n {
+				t.Errorf("received all %d messages, want some dropped", n)
+			}
+			select {
+			case <-disconnected:
+				t.Error("slow consumer disconnected")
+			default:
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+)
+
+// OpError is the reserved operation of error responses, the payload is an Error.
+// Operations from 0xFF00 are reserved for the protocol.
+const OpError uint16 = 0xFFFD
+
+const opReserved uint16 = 0xFF00
+
+// Error codes of error responses.
+const (
+	CodeUnknownOperation = 1
+	CodeInvalidPayload   = 2
+	CodeInternal         = 3
+	CodeHandshake        = 4
+)
+
+var ErrPayloadType = errors.New("protocol: payload type does not match the operation")
+
+// Error is the payload of error responses. Handlers may return it to choose the code.
+type Error struct {
+	Code    int    `json:"code" msgpack:"code"`
+	Message string `json:"message" msgpack:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("protocol: error %d: %s", e.Code, e.Message)
+}
+
+// Operation describes an operation code and the Go types of its payloads.
+type Operation struct {
+	Code     uint16
+	Name     string
+	Request  reflect.Type
+	Response reflect.Type
+}
+
+// Registry maps operation codes to their request and response types.
+// A Registry can be shared by a Server and its Clients with WithRegistry.
+type Registry struct {
+	mu  sync.RWMutex
+	ops map[uint16]Operation
+}
+
+func NewRegistry() *Registry {
+	return &Registry{ops: make(map[uint16]Operation)}
+}
+
+// Register maps the operation code to the types Req and Resp. Registering a code again with other types fails.
+func Register[Req, Resp any](r *Registry, code uint16, name string) error {
+	return r.register(Operation{
+		Code:     code,
+		Name:     name,
+		Request:  reflect.TypeFor[Req](),
+		Response: reflect.TypeFor[Resp](),
+	})
+}
+
+func (r *Registry) register(op Operation) error {
+	if op.Code >= opReserved {
+		return fmt.Errorf("protocol: operation %#x is reserved", op.Code)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if prev, ok := r.ops[op.Code]; ok {
+		if prev.Request != op.Request || prev.Response != op.Response {
+			return fmt.Errorf("protocol: operation %d is registered as %s(%s) %s", op.Code, prev.Name, prev.Request, prev.Response)
+		}
+		if op.Name == "" {
+			op.Name = prev.Name
+		}
+	}
+	r.ops[op.Code] = op
+	return nil
+}
+
+// Lookup returns the operation registered with code.
+func (r *Registry) Lookup(code uint16) (Operation, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	op, ok := r.ops[code]
+	return op, ok
+}
+
+// checkType checks that t is the request or the response type of the operation, if it is registered.
+func (r *Registry) checkType(code uint16, t reflect.Type) error {
+	op, ok := r.Lookup(code)
+	if !ok || t == op.Request || t == op.Response {
+		return nil
+	}
+	return fmt.Errorf("%w: %s for %s(%s) %s", ErrPayloadType, t, op.Name, op.Request, op.Response)
+}
+
+// Handler is implemented by Server and Client.
+type Handler interface {
+	Handle(op uint16, fn HandlerFunc)
+	Registry() *Registry
+}
+
+// Handle registers a handler receiving decoded requests of type Req, and replying with its Resp,
+// or with an error response if it returns an error. The types are registered in the registry of h.
+//
+//	protocol.Handle(s, opAdd, func(ctx context.Context, c *protocol.Conn, req AddRequest) (AddResponse, error) {
+//		return AddResponse{Sum: req.A + req.B}, nil
+//	})
+func Handle[Req, Resp any](h Handler, op uint16, fn func(ctx context.Context, c *Conn, req Req) (Resp, error)) error {
+	if err := Register[Req, Resp](h.Registry(), op, ""); err != nil {
+		return err
+	}
+
+	h.Handle(op, func(ctx context.Context, c *Conn, pkt *Packet) {
+		var req Req
+		if err := c.Codec().Unmarshal(pkt.Payload, &req); err != nil {
+			c.replyError(pkt, &Error{Code: CodeInvalidPayload, Message: err.Error()})
+			return
+		}
+
+		resp, err := fn(ctx, c, req)
+		if err != nil {
+			var e *Error
+			if !errors.As(err, &e) {
+				e = &Error{Code: CodeInternal, Message: err.Error()}
+			}
+			c.replyError(pkt, e)
+			return
+		}
+
+		payload, err := c.Codec().Marshal(resp)
+		if err != nil {
+			c.replyError(pkt, &Error{Code: CodeInternal, Message: err.Error()})
+			return
+		}
+		if err := c.Reply(pkt, payload); err != nil {
+			slog.Debug("protocol: reply", "op", op, "err", err)
+		}
+	})
+	return nil
+}
+
+// Send queues a packet of operation op with v encoded by the codec of c.
+func Send[T any](c *Conn, op uint16, v T, opts ...PacketOption) error {
+	if err := c.opts.registry.checkType(op, reflect.TypeFor[T]()); err != nil {
+		return err
+	}
+	payload, err := c.Codec().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(NewPacket(op, payload, opts...))
+}
+
+// Decode decodes the payload of pkt with the codec of c.
+func Decode[T any](c *Conn, pkt *Packet) (T, error) {
+	var v T
+	err := c.Codec().Unmarshal(pkt.Payload, &v)
+	return v, err
+}
+
+// Call calls operation op with req encoded by the codec of the client, and decodes the response.
+// An error response is returned as *Error.
+func Call[Req, Resp any](ctx context.Context, client *Client, op uint16, req Req) (Resp, error) {
+	var resp Resp
+	if err := client.opts.registry.checkType(op, reflect.TypeFor[Req]()); err != nil {
+		return resp, err
+	}
+	payload, err := client.opts.codec.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	pkt, err := client.Call(ctx, op, payload)
+	if err != nil {
+		return resp, err
+	}
+	if err := client.opts.codec.Unmarshal(pkt.Payload, &resp); err != nil {
+		return resp, fmt.Errorf("protocol: decode response of operation %d: %w", op, err)
+	}
+	return resp, nil
+}
+
+// replyError replies to req with an error response, unless req is itself a reserved operation.
+func (c *Conn) replyError(req *Packet, e *Error) {
+	if req.Header.Operation >= opReserved {
+		return
+	}
+	c.sendError(req, e)
+}
+
+// sendError replies to req with an error response.
+func (c *Conn) sendError(req *Packet, e *Error) {
+	payload, err := c.Codec().Marshal(e)
+	if err != nil {
+		slog.Warn("protocol: encode error response", "err", err)
+		return
+	}
+	pkt := NewPacket(OpError, payload, replyTo(req))
+	if err := c.Send(pkt); err != nil {
+		slog.Debug("protocol: reply", "op", OpError, "err", err)
+	}
+}
+
+// responseError returns the Error of an error response.
+func (c *Conn) responseError(pkt *Packet) error {
+	e := &Error{}
+	if err := c.Codec().Unmarshal(pkt.Payload, e); err != nil {
+		return fmt.Errorf("protocol: decode error response: %w", err)
+	}
+	return e
+}
@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	opAdd uint16 = iota + 10
+	opFail
+	opUnknown
+)
+
+type addRequest struct {
+	A, B int
+}
+
+type addResponse struct {
+	Sum int
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob, MessagePack} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			data, err := codec.Marshal(addRequest{A: 1, B: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got addRequest
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != (addRequest{A: 1, B: 2}) {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
+
+func TestTypedCall(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob, MessagePack} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			s, addr := newTestServer(t, WithCodec(codec))
+			err := Handle(s, opAdd, func(ctx context.Context, c *Conn, req addRequest) (addResponse, error) {
+				return addResponse{Sum: req.A + req.B}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			Handle(s, opFail, func(ctx context.Context, c *Conn, req addRequest) (addResponse, error) {
+				if req.A < 0 {
+					return addResponse{}, &Error{Code: 100, Message: "negative"}
+				}
+				return addResponse{}, errors.New("failed")
+			})
+
+			client := NewClient(addr, WithCodec(codec))
+			defer client.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			resp, err := Call[addRequest, addResponse](ctx, client, opAdd, addRequest{A: 1, B: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Sum != 3 {
+				t.Errorf("sum = %d, want 3", resp.Sum)
+			}
+
+			tests := []struct {
+				op       uint16
+				req      addRequest
+				wantCode int
+			}{
+				{opFail, addRequest{A: -1}, 100},
+				{opFail, addRequest{A: 1}, CodeInternal},
+				{opUnknown, addRequest{}, CodeUnknownOperation},
+			}
+			for _, tt := range tests {
+				_, err := Call[addRequest, addResponse](ctx, client, tt.op, tt.req)
+				var e *Error
+				if !errors.As(err, &e) || e.Code != tt.wantCode {
+					t.Errorf("op %d: err = %v, want code %d", tt.op, err, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidPayload(t *testing.T) {
+	s, addr := newTestServer(t)
+	Handle(s, opAdd, func(ctx context.Context, c *Conn, req addRequest) (addResponse, error) {
+		return addResponse{Sum: req.A + req.B}, nil
+	})
+
+	client := NewClient(addr)
+	defer client.Close()
+	_, err := client.Call(context.Background(), opAdd, []byte("not json"))
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeInvalidPayload {
+		t.Errorf("err = %v, want code %d", err, CodeInvalidPayload)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if err := Register[addRequest, addResponse](r, opAdd, "add"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register[addRequest, addResponse](r, opAdd, ""); err != nil {
+		t.Errorf("registering the same types again: %v", err)
+	}
+	if op, _ := r.Lookup(opAdd); op.Name != "add" {
+		t.Errorf("name = %q, want add", op.Name)
+	}
+	if err := Register[string, addResponse](r, opAdd, "add"); err == nil {
+		t.Error("registering other types succeeded")
+	}
+	if err := Register[string, string](r, OpPing, "ping"); err == nil {
+		t.Error("registering a reserved operation succeeded")
+	}
+
+	if err := r.checkType(opAdd, reflect.TypeFor[addResponse]()); err != nil {
+		t.Error(err)
+	}
+	if err := r.checkType(opAdd, reflect.TypeFor[string]()); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("err = %v, want ErrPayloadType", err)
+	}
+
+	c := newConn(nil, newOptions([]Option{WithRegistry(r)}))
+	if err := Send(c, opAdd, "a string"); !errors.Is(err, ErrPayloadType) {
+		t.Errorf("Send err = %v, want ErrPayloadType", err)
+	}
+}
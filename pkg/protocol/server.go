@@ -0,0 +1,216 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown or Close.
+var ErrServerClosed = errors.New("protocol: server closed")
+
+// Server accepts connections, and dispatches the packets received on them to the handler of their operation.
+//
+//	s := protocol.NewServer()
+//	s.Handle(opEcho, func(ctx context.Context, c *protocol.Conn, pkt *protocol.Packet) {
+//		c.Reply(pkt, pkt.Payload)
+//	})
+//	go s.ListenAndServe(":9000")
+type Server struct {
+	handlers
+	opts *options
+
+	mu        sync.Mutex
+	listeners map[io.Closer]struct{} // net.Listener and net.PacketConn
+	conns     map[*Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup // connections
+}
+
+func NewServer(opts ...Option) *Server {
+	return &Server{
+		opts:      newOptions(opts),
+		listeners: make(map[io.Closer]struct{}),
+		conns:     make(map[*Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed. It always returns a non-nil error,
+// ErrServerClosed after Shutdown or Close.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+
+	var wait time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// e.g. too many open files
+				wait = min(max(2*wait, 5*time.Millisecond), time.Second)
+				slog.Warn("protocol: accept error", "err", err, "retryIn", wait)
+				time.Sleep(wait)
+				continue
+			}
+			return err
+		}
+		wait = 0
+
+		c := newConn(nc, s.opts)
+		c.handlers = &s.handlers
+		if !s.trackConn(c, true) {
+			c.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *Server) serveConn(c *Conn) {
+	defer s.wg.Done()
+	defer s.trackConn(c, false)
+
+	if err := c.handshake(false); err != nil {
+		slog.Warn("protocol: handshake", "remote", c.RemoteAddr(), "err", err)
+		c.Close()
+		return
+	}
+	if s.opts.onConnect != nil {
+		s.opts.onConnect(c)
+	}
+	err := c.serve(func(pkt *Packet) {
+		s.dispatch(c, pkt)
+	})
+	<-c.done
+	if s.opts.onDisconnect != nil {
+		s.opts.onDisconnect(c, err)
+	}
+}
+
+func (s *Server) dispatch(c *Conn, pkt *Packet) {
+	h, ok := s.handler(pkt.Header.Operation)
+	if !ok {
+		slog.Debug("protocol: no handler", "op", pkt.Header.Operation, "remote", c.RemoteAddr())
+		c.replyError(pkt, &Error{Code: CodeUnknownOperation, Message: fmt.Sprintf("unknown operation %d", pkt.Header.Operation)})
+		return
+	}
+	c.handle(func() { h(c.Context(), c, pkt) })
+}
+
+// Registry returns the registry of operations.
+func (s *Server) Registry() *Registry {
+	return s.opts.registry
+}
+
+// Conns returns the open connections.
+func (s *Server) Conns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Shutdown stops accepting connections, then closes the connections once their queued packets are written.
+// If ctx is done first, the remaining connections are closed and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeListeners()
+	for _, c := range s.Conns() {
+		c.drain()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and the connections immediately.
+func (s *Server) Close() error {
+	s.closeListeners()
+	for _, c := range s.Conns() {
+		c.Close()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) closeListeners() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+func (s *Server) trackListener(l io.Closer, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) trackConn(c *Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+	} else {
+		delete(s.conns, c)
+	}
+	return true
+}
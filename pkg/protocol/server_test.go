@@ -0,0 +1,332 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	opEcho uint16 = iota + 1
+	opPush
+	opSlow
+)
+
+func newTestServer(t *testing.T, opts ...Option) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(opts...)
+	s.Handle(opEcho, func(ctx context.Context, c *Conn, pkt *Packet) {
+		c.Reply(pkt, pkt.Payload)
+	})
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func receive(t *testing.T, ch <-chan *Packet) *Packet {
+	t.Helper()
+	select {
+	case pkt := <-ch:
+		return pkt
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func TestServerClient(t *testing.T) {
+	var connected, disconnected atomic.Int32
+	s, addr := newTestServer(t,
+		WithOnConnect(func(c *Conn) { connected.Add(1) }),
+		WithOnDisconnect(func(c *Conn, err error) { disconnected.Add(1) }),
+	)
+
+	client := NewClient(addr, WithReconnect(10*time.Millisecond, 50*time.Millisecond))
+	defer client.Close()
+	received := make(chan *Packet, 1)
+	client.Handle(opEcho, func(ctx context.Context, c *Conn, pkt *Packet) {
+		received <- pkt
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Send(ctx, NewPacket(opEcho, []byte("hello"), WithSequenceID(1))); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := receive(t, received); string(pkt.Payload) != "hello" || pkt.Header.SequenceID != 1 {
+		t.Errorf("got %+v %q", pkt.Header, pkt.Payload)
+	}
+	if n := len(s.Conns()); n != 1 {
+		t.Errorf("conns = %d, want 1", n)
+	}
+
+	// the client reconnects when the server drops the connection
+	for _, c := range s.Conns() {
+		c.Close()
+	}
+	// packets sent before the client notices are lost, send until one is echoed
+	for again := false; !again; {
+		if err := client.Send(ctx, NewPacket(opEcho, []byte("again"), WithSequenceID(2))); err != nil && !errors.Is(err, ErrConnClosed) {
+			t.Fatal(err)
+		}
+		select {
+		case pkt := <-received:
+			again = string(pkt.Payload) == "again"
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+	if connected.Load() < 2 || disconnected.Load() < 1 {
+		t.Errorf("connected = %d, disconnected = %d", connected.Load(), disconnected.Load())
+	}
+}
+
+func TestServerPush(t *testing.T) {
+	connected := make(chan *Conn, 1)
+	_, addr := newTestServer(t, WithOnConnect(func(c *Conn) { connected <- c }))
+
+	client := NewClient(addr)
+	defer client.Close()
+	received := make(chan *Packet, 1)
+	client.Handle(opPush, func(ctx context.Context, c *Conn, pkt *Packet) {
+		received <- pkt
+	})
+
+	var c *Conn
+	select {
+	case c = <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if err := c.Send(NewPacket(opPush, []byte("news"))); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := receive(t, received); string(pkt.Payload) != "news" {
+		t.Errorf("payload = %q", pkt.Payload)
+	}
+}
+
+func TestHandlerCall(t *testing.T) {
+	connected := make(chan *Conn, 1)
+	_, addr := newTestServer(t, WithOnConnect(func(c *Conn) { connected <- c }))
+
+	// the handler calls back the server, its response is read while the handler waits
+	client := NewClient(addr, WithCallTimeout(5*time.Second))
+	defer client.Close()
+	replies := make(chan string, 1)
+	client.Handle(opPush, func(ctx context.Context, c *Conn, pkt *Packet) {
+		resp, err := client.Call(ctx, opEcho, pkt.Payload)
+		if err != nil {
+			replies <- err.Error()
+			return
+		}
+		replies <- string(resp.Payload)
+	})
+
+	var c *Conn
+	select {
+	case c = <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if err := c.Send(NewPacket(opPush, []byte("ping back"))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reply := <-replies:
+		if reply != "ping back" {
+			t.Errorf("reply = %q", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the handler call did not return")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	connected := make(chan *Conn, 1)
+	s, addr := newTestServer(t, WithOnConnect(func(c *Conn) { connected <- c }))
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c := <-connected
+
+	// queued packets are written before the connection is closed
+	for i := range 3 {
+		if err := c.Send(NewPacket(opPush, nil, WithSequenceID(uint32(i+1)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(nc)
+	for i := range 3 {
+		var pkt Packet
+		if err := dec.Decode(&pkt); err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Header.SequenceID != uint32(i+1) {
+			t.Errorf("sequence = %d, want %d", pkt.Header.SequenceID, i+1)
+		}
+	}
+	if err := c.Send(NewPacket(opPush, nil)); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Send after shutdown = %v, want ErrConnClosed", err)
+	}
+	if n := len(s.Conns()); n != 0 {
+		t.Errorf("conns = %d, want 0", n)
+	}
+	if err := s.ListenAndServe("127.0.0.1:0"); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve after shutdown = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestConnQueueFull(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := newConn(a, newOptions([]Option{WithQueueSize(1)}))
+	defer c.Close()
+
+	// no write loop, nothing is dequeued
+	if err := c.Send(NewPacket(opPush, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(NewPacket(opPush, nil)); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("err = %v, want ErrQueueFull", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.SendContext(ctx, NewPacket(opPush, nil)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	s, addr := newTestServer(t)
+	s.Handle(opPush, func(ctx context.Context, c *Conn, pkt *Packet) {
+		// pushes before replying, the push must not be taken as the response
+		c.Send(NewPacket(opPush, []byte("push")))
+		c.Reply(pkt, append([]byte("re: "), pkt.Payload...))
+	})
+	s.Handle(opSlow, func(ctx context.Context, c *Conn, pkt *Packet) {})
+
+	client := NewClient(addr, WithCallTimeout(50*time.Millisecond))
+	defer client.Close()
+	pushed := make(chan *Packet, 1)
+	client.HandleDefault(func(ctx context.Context, c *Conn, pkt *Packet) {
+		pushed <- pkt
+	})
+
+	ctx := context.Background()
+	resp, err := client.Call(ctx, opEcho, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload) != "hello" {
+		t.Errorf("payload = %q", resp.Payload)
+	}
+
+	resp, err = client.Call(ctx, opPush, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload) != "re: hi" {
+		t.Errorf("payload = %q", resp.Payload)
+	}
+	if pkt := receive(t, pushed); string(pkt.Payload) != "push" {
+		t.Errorf("pushed = %q", pkt.Payload)
+	}
+
+	if _, err := client.Call(ctx, opSlow, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+
+	// concurrent calls get their own response
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := strconv.Itoa(i)
+			resp, err := client.Call(ctx, opEcho, []byte(want))
+			if err != nil {
+				t.Error(err)
+			} else if string(resp.Payload) != want {
+				t.Errorf("payload = %q, want %q", resp.Payload, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientCallSequenceCollision(t *testing.T) {
+	s, addr := newTestServer(t)
+	s.Handle(opSlow, func(ctx context.Context, c *Conn, pkt *Packet) {
+		// pushes numbered by the server may reuse the sequence ID of the call
+		c.Send(NewPacket(opPush, []byte("push"), WithSequenceID(pkt.Header.SequenceID)))
+		c.Send(NewPacket(opSlow, []byte("push"), WithSequenceID(pkt.Header.SequenceID)))
+		c.Reply(pkt, []byte("reply"))
+	})
+
+	client := NewClient(addr, WithCallTimeout(5*time.Second))
+	defer client.Close()
+	pushed := make(chan *Packet, 2)
+	client.HandleDefault(func(ctx context.Context, c *Conn, pkt *Packet) {
+		pushed <- pkt
+	})
+
+	resp, err := client.Call(context.Background(), opSlow, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload) != "reply" {
+		t.Errorf("payload = %q, want the reply", resp.Payload)
+	}
+	for _, op := range []uint16{opPush, opSlow} {
+		if pkt := receive(t, pushed); pkt.Header.Operation != op || string(pkt.Payload) != "push" {
+			t.Errorf("pushed op %d %q, want op %d", pkt.Header.Operation, pkt.Payload, op)
+		}
+	}
+}
+
+func TestClientCallConnLost(t *testing.T) {
+	s, addr := newTestServer(t)
+	s.Handle(opSlow, func(ctx context.Context, c *Conn, pkt *Packet) {
+		c.Close()
+	})
+
+	client := NewClient(addr)
+	defer client.Close()
+	if _, err := client.Call(context.Background(), opSlow, nil); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("err = %v, want ErrConnClosed", err)
+	}
+}
+
+func TestNextSequenceID(t *testing.T) {
+	a, b := NewPacket(opEcho, nil), NewPacket(opEcho, nil)
+	if b.Header.SequenceID != a.Header.SequenceID+1 {
+		t.Errorf("sequence IDs %d, %d are not monotonic", a.Header.SequenceID, b.Header.SequenceID)
+	}
+
+	sequenceID.Store(math.MaxUint32)
+	if id := NextSequenceID(); id != 1 {
+		t.Errorf("after wrap around = %d, want 1", id)
+	}
+}
@@ -0,0 +1,434 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxDatagramSize is the max size of a UDP datagram, packets must fit in one.
+const maxDatagramSize = 64 << 10
+
+// WithCheckOrigin sets the function that accepts WebSocket handshakes by origin, the same origin only by default.
+func WithCheckOrigin(fn func(r *http.Request) bool) Option {
+	return func(o *options) {
+		o.checkOrigin = fn
+	}
+}
+
+// messageConn is a net.Conn carrying one packet per message.
+type messageConn interface {
+	net.Conn
+	// NextReader returns a reader of the next message.
+	NextReader() (io.Reader, error)
+}
+
+// DatagramStats counts the packets dropped or reordered on a UDP connection.
+// Replies, with FlagReply, are not checked: their sequence IDs are the ones of our calls.
+type DatagramStats struct {
+	// Invalid counts the datagrams that are not a valid packet
+	Invalid uint64
+	// Duplicates counts the packets whose sequence ID was already received
+	Duplicates uint64
+	// Reordered counts the packets received after a packet of the same operation with a later sequence ID
+	Reordered uint64
+}
+
+// DatagramStats returns the counters of a UDP connection, zero for other transports.
+func (c *Conn) DatagramStats() DatagramStats {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+
+	return c.dstats
+}
+
+// read reads the next packet, one per message on WebSocket and UDP connections.
+func (c *Conn) read(pkt *Packet) error {
+	mc, ok := c.conn.(messageConn)
+	if !ok {
+		return c.dec.Decode(pkt)
+	}
+
+	for {
+		r, err := mc.NextReader()
+		if err != nil {
+			return err
+		}
+		c.dec.r = r
+		err = c.dec.Decode(pkt)
+		if err == nil {
+			err = unpacked(r, pkt)
+		}
+		if !c.datagram {
+			return err
+		}
+
+		// datagrams are lost, duplicated and reordered independently. The windows hold the sequence IDs
+		// chosen by the peer, replies carry the IDs of our calls.
+		result := seqInOrder
+		if err == nil && pkt.Header.SequenceID != 0 && pkt.Header.Flags&FlagReply == 0 {
+			w := c.windows[pkt.Header.Operation]
+			if w == nil {
+				w = &seqWindow{}
+				c.windows[pkt.Header.Operation] = w
+			}
+			result = w.check(pkt.Header.SequenceID)
+		}
+		c.dmu.Lock()
+		switch {
+		case err != nil:
+			c.dstats.Invalid++
+		case result == seqDuplicate:
+			c.dstats.Duplicates++
+			err = errDuplicate
+		case result == seqReordered:
+			c.dstats.Reordered++
+		}
+		c.dmu.Unlock()
+		if err == nil {
+			return nil
+		}
+		slog.Debug("protocol: datagram dropped", "remote", c.RemoteAddr(), "err", err)
+	}
+}
+
+var errDuplicate = errors.New("protocol: duplicate packet")
+
+// unpacked checks that the message has nothing after the packet, as Unpack.
+func unpacked(r io.Reader, pkt *Packet) error {
+	var b [1]byte
+	if n, _ := r.Read(b[:]); n > 0 {
+		return ErrInvalidLength
+	}
+	return pkt.validate()
+}
+
+type seqResult int
+
+const (
+	seqInOrder seqResult = iota
+	seqDuplicate
+	seqReordered
+)
+
+// seqWindowSize is the number of sequence IDs before the last one whose reception is remembered.
+const seqWindowSize = 1024
+
+// seqWindow detects duplicate and reordered sequence IDs, with a bitmap of the received IDs indexed modulo its size.
+type seqWindow struct {
+	started bool
+	last    uint32
+	bits    [seqWindowSize / 64]uint64
+}
+
+func (w *seqWindow) check(seq uint32) seqResult {
+	d := int32(seq - w.last)
+	switch {
+	case !w.started, d <= -seqWindowSize, d >= seqWindowSize:
+		// far from the last ID, e.g. the peer restarted
+		w.started = true
+		clear(w.bits[:])
+	case d > 0:
+		for id := w.last + 1; id != seq; id++ {
+			w.bits[id/64%(seqWindowSize/64)] &^= 1 << (id % 64)
+		}
+	default:
+		bit := &w.bits[seq/64%(seqWindowSize/64)]
+		if *bit&(1<<(seq%64)) != 0 {
+			return seqDuplicate
+		}
+		*bit |= 1 << (seq % 64)
+		return seqReordered
+	}
+	w.last = seq
+	w.bits[seq/64%(seqWindowSize/64)] |= 1 << (seq % 64)
+	return seqInOrder
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection, and serves it. Each binary message carries one packet.
+//
+//	http.Handle("/protocol", s)
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.opts.checkOrigin}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has replied with an error
+		return
+	}
+
+	c := newConn(&wsConn{ws: ws}, s.opts)
+	c.handlers = &s.handlers
+	if !s.trackConn(c, true) {
+		c.Close()
+		return
+	}
+	s.serveConn(c)
+}
+
+// ListenAndServeUDP listens on the UDP address addr and serves datagrams.
+func (s *Server) ListenAndServeUDP(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeUDP(pc)
+}
+
+// ServeUDP serves the datagrams received on pc until it is closed, each one carrying one packet.
+// The datagrams of each remote address are served as a connection, closed by the idle timeout.
+// Duplicate packets are dropped, see Conn.DatagramStats, and streams are not supported.
+// It always returns a non-nil error, ErrServerClosed after Shutdown or Close.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	if !s.trackListener(pc, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(pc, false)
+
+	var mu sync.Mutex
+	peers := make(map[string]*udpPeer)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, p := range peers {
+			p.closeOnce.Do(func() { close(p.closed) })
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		key := addr.String()
+		mu.Lock()
+		p, ok := peers[key]
+		if !ok {
+			p = &udpPeer{pc: pc, addr: addr, in: make(chan []byte, s.opts.queueSize), closed: make(chan struct{})}
+			p.onClose = func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if peers[key] == p {
+					delete(peers, key)
+				}
+			}
+			peers[key] = p
+		}
+		mu.Unlock()
+
+		if !ok {
+			c := newConn(p, s.opts)
+			c.handlers = &s.handlers
+			if !s.trackConn(c, true) {
+				c.Close()
+				return ErrServerClosed
+			}
+			go s.serveConn(c)
+		}
+		select {
+		case p.in <- bytes.Clone(buf[:n]):
+		default:
+			// the connection is behind, as if the datagram was lost
+		}
+	}
+}
+
+// dial connects to the address of the client: a TCP address, a ws:// or wss:// URL, or a udp:// address.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	switch {
+	case strings.HasPrefix(c.addr, "ws://"), strings.HasPrefix(c.addr, "wss://"):
+		d := *websocket.DefaultDialer
+		d.NetDialContext = c.opts.dial
+		ws, _, err := d.DialContext(ctx, c.addr, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &wsConn{ws: ws}, nil
+	case strings.HasPrefix(c.addr, "udp://"):
+		nc, err := c.opts.dial(ctx, "udp", strings.TrimPrefix(c.addr, "udp://"))
+		if err != nil {
+			return nil, err
+		}
+		return &datagramConn{Conn: nc, buf: make([]byte, maxDatagramSize)}, nil
+	}
+	return c.opts.dial(ctx, "tcp", c.addr)
+}
+
+// wsConn carries one packet per WebSocket binary message.
+type wsConn struct {
+	ws        *websocket.Conn
+	r         io.Reader // of Read
+	closeOnce sync.Once
+}
+
+func (c *wsConn) NextReader() (io.Reader, error) {
+	for {
+		typ, r, err := c.ws.NextReader()
+		if err != nil {
+			return nil, err
+		}
+		if typ == websocket.BinaryMessage {
+			return r, nil
+		}
+	}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.r == nil {
+			r, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(p)
+		if err == io.EOF {
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close message before closing the connection.
+func (c *wsConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		err = c.ws.Close()
+	})
+	return err
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	c.ws.SetReadDeadline(t)
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+// datagramConn carries one packet per datagram of a connected UDP socket.
+type datagramConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *datagramConn) NextReader() (io.Reader, error) {
+	n, err := c.Conn.Read(c.buf)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(c.buf[:n]), nil
+}
+
+// udpPeer is the connection of a remote address of a server UDP socket, fed by ServeUDP.
+type udpPeer struct {
+	pc   net.PacketConn
+	addr net.Addr
+	in   chan []byte
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func (p *udpPeer) NextReader() (io.Reader, error) {
+	p.mu.Lock()
+	deadline := p.readDeadline
+	p.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case b := <-p.in:
+		return bytes.NewReader(b), nil
+	case <-p.closed:
+		return nil, net.ErrClosed
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *udpPeer) Read(b []byte) (int, error) {
+	r, err := p.NextReader()
+	if err != nil {
+		return 0, err
+	}
+	return r.Read(b)
+}
+
+func (p *udpPeer) Write(b []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return p.pc.WriteTo(b, p.addr)
+}
+
+// Close stops serving the remote address, the socket is closed by the server.
+func (p *udpPeer) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.onClose()
+	})
+	return nil
+}
+
+func (p *udpPeer) LocalAddr() net.Addr  { return p.pc.LocalAddr() }
+func (p *udpPeer) RemoteAddr() net.Addr { return p.addr }
+
+func (p *udpPeer) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *udpPeer) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline does nothing, datagrams are written without waiting.
+func (p *udpPeer) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,219 @@
+package protocol
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransports(t *testing.T) {
+	s, addr := newTestServer(t)
+	if err := Handle(s, opAdd, func(ctx context.Context, c *Conn, req addRequest) (addResponse, error) {
+		return addResponse{Sum: req.A + req.B}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeUDP(pc)
+
+	tests := []struct {
+		name string
+		addr string
+	}{
+		{"tcp", addr},
+		{"websocket", "ws" + strings.TrimPrefix(hs.URL, "http") + "/protocol"},
+		{"udp", "udp://" + pc.LocalAddr().String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(tt.addr)
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, err := client.Call(ctx, opEcho, []byte("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(resp.Payload) != "hello" {
+				t.Errorf("echo = %q", resp.Payload)
+			}
+
+			sum, err := Call[addRequest, addResponse](ctx, client, opAdd, addRequest{A: 1, B: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum.Sum != 3 {
+				t.Errorf("sum = %d, want 3", sum.Sum)
+			}
+		})
+	}
+}
+
+func TestTransportHandshake(t *testing.T) {
+	s, _ := newTestServer(t, WithHandshake([]byte("secret")))
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeUDP(pc)
+
+	tests := []struct {
+		name     string
+		addr     string
+		features uint32
+	}{
+		{"websocket", "ws" + strings.TrimPrefix(hs.URL, "http"), FeatureCompression | FeatureFragmentation},
+		{"udp", "udp://" + pc.LocalAddr().String(), FeatureCompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(tt.addr, WithHandshake([]byte("secret")))
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := client.Conn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conn.Version() != Version3 || conn.Features() != tt.features {
+				t.Errorf("version %d, features %b, want %d, %b", conn.Version(), conn.Features(), Version3, tt.features)
+			}
+			if _, err := client.Call(ctx, opEcho, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDatagramDuplicates(t *testing.T) {
+	s, _ := newTestServer(t)
+	received := make(chan *Packet, 16)
+	s.Handle(opPush, func(ctx context.Context, c *Conn, pkt *Packet) {
+		received <- pkt
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeUDP(pc)
+
+	nc, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	for _, seq := range []uint32{5, 6, 6, 4, 0, 7, 4} {
+		var data []byte
+		if seq == 0 {
+			data = []byte("not a packet")
+		} else {
+			data, _ = Pack(NewPacket(opPush, nil, WithSequenceID(seq)))
+		}
+		if _, err := nc.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []uint32{5, 6, 4, 7} {
+		if pkt := receive(t, received); pkt.Header.SequenceID != want {
+			t.Errorf("sequence ID %d, want %d", pkt.Header.SequenceID, want)
+		}
+	}
+
+	// the last duplicate is dropped after the packets are dispatched
+	deadline := time.Now().Add(5 * time.Second)
+	want := DatagramStats{Invalid: 1, Duplicates: 2, Reordered: 1}
+	for {
+		conns := s.Conns()
+		if len(conns) == 1 && conns[0].DatagramStats() == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d conns, want 1 with stats %+v", len(conns), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDatagramReplies(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Handle(opSlow, func(ctx context.Context, c *Conn, pkt *Packet) {
+		// the push has the sequence ID of the pending call, its duplicate is dropped all the same
+		push := NewPacket(opSlow, []byte("push"), WithSequenceID(pkt.Header.SequenceID))
+		c.Send(push)
+		c.Send(push)
+		c.Reply(pkt, []byte("reply"))
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeUDP(pc)
+
+	client := NewClient("udp://" + pc.LocalAddr().String())
+	defer client.Close()
+	pushed := make(chan *Packet, 1)
+	client.Handle(opSlow, func(ctx context.Context, c *Conn, pkt *Packet) {
+		pushed <- pkt
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := client.Call(ctx, opSlow, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload) != "reply" {
+		t.Errorf("reply = %q", resp.Payload)
+	}
+	if pkt := receive(t, pushed); string(pkt.Payload) != "push" {
+		t.Errorf("push = %q", pkt.Payload)
+	}
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := conn.DatagramStats(); stats != (DatagramStats{Duplicates: 1}) {
+		t.Errorf("stats = %+v, want the duplicate push dropped", stats)
+	}
+}
+
+func TestSeqWindow(t *testing.T) {
+	tests := []struct {
+		seq  uint32
+		want seqResult
+	}{
+		{100, seqInOrder},
+		{102, seqInOrder},
+		{101, seqReordered},
+		{101, seqDuplicate},
+		{102, seqDuplicate},
+		{200 + seqWindowSize, seqInOrder},
+		{101, seqInOrder}, // too old, taken as a restart
+		{101, seqDuplicate},
+		{0x80000000, seqInOrder},
+		{0xFFFFFFFF, seqInOrder},
+		{3, seqInOrder}, // wraps around
+		{0xFFFFFFFF, seqDuplicate},
+	}
+	var w seqWindow
+	for i, tt := range tests {
+		if got := w.check(tt.seq); got != tt.want {
+			t.Errorf("%d: check(%d) = %d, want %d", i, tt.seq, got, tt.want)
+		}
+	}
+}
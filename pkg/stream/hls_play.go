@@ -1,6 +1,8 @@
 package stream
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/bluenviron/gohlslib/pkg/playlist"
+	"github.com/bluenviron/gohlslib/pkg/playlist/primitives"
 )
 
 const defaultLiveStartIndex = -3
@@ -19,14 +22,27 @@ type (
 	OnRequestFunc            func(*http.Request)
 	OnPlaylistDownloadedFunc func([]byte, playlist.Playlist)
 	OnSegmentDownloadedFunc  func([]byte)
+	OnStartFunc              func(seqNo int, offset time.Duration)
 	FetchSegmentFunc         func(string, int) error
 )
 
+// StartOffset is where playback starts, like the EXT-X-START tag.
+type StartOffset struct {
+	// Offset from the start of the playlist if positive, from the end of its last segment if negative
+	TimeOffset time.Duration
+	// Start at this EXT-X-PROGRAM-DATE-TIME instead of TimeOffset, if not zero
+	ProgramDateTime time.Time
+	// Start at the exact offset, instead of the start of the segment containing it
+	Precise bool
+}
+
 type HLSPlayer struct {
 	// URI of the playlist
 	URI string
 	// Segment index to start live streams at (negative values are from the end)
 	LiveStartIndex *int
+	// Start position overriding the EXT-X-START of the playlist (optional)
+	StartOffset *StartOffset
 	// HTTP client used for making requests
 	HTTPClient *http.Client
 
@@ -38,6 +54,8 @@ type HLSPlayer struct {
 	OnPlaylistDownloaded OnPlaylistDownloadedFunc
 	// Called after downloading a segment
 	OnSegmentDownloaded OnSegmentDownloadedFunc
+	// Called with the first segment and the position in it where playback starts
+	OnStart OnStartFunc
 	// Custom function for fetching media segments
 	FetchSegment FetchSegmentFunc
 
@@ -88,6 +106,9 @@ func (c *HLSPlayer) Start() error {
 	if c.OnSegmentDownloaded == nil {
 		c.OnSegmentDownloaded = func([]byte) {}
 	}
+	if c.OnStart == nil {
+		c.OnStart = func(int, time.Duration) {}
+	}
 
 	if c.FetchSegment == nil {
 		c.FetchSegment = func(url string, _ int) error {
@@ -135,6 +156,7 @@ func (c *HLSPlayer) run() error {
 	c.OnPlaylistDownloaded(b, pl)
 
 	var pls *playlist.Media
+	start := c.StartOffset
 
 	switch plt := pl.(type) {
 	case *playlist.Multivariant: // Master Playlist
@@ -149,24 +171,36 @@ func (c *HLSPlayer) run() error {
 		}
 		c.playlistURL = u
 
-		mediaPlaylist, err := c.fetchMediaPlaylist(u.String())
+		mediaPlaylist, mb, err := c.fetchMediaPlaylist(u.String())
 		if err != nil {
 			return err
 		}
 
 		pls = mediaPlaylist
+		// EXT-X-START of the media playlist, or else of the multivariant playlist
+		if start == nil {
+			start = playlistStart(mb, pls.Start)
+		}
+		if start == nil {
+			start = playlistStart(b, plt.Start)
+		}
 
 		if leadingPlaylist.Audio != "" {
 			// TODO(Lysander)
 		}
 	case *playlist.Media: // Media Playlist
 		pls = plt
+		if start == nil {
+			start = playlistStart(b, pls.Start)
+		}
 	default:
 		return fmt.Errorf("invalid playlist")
 	}
 
 	// Select the starting segments
-	c.curSeqNo = selectCurSeqNo(*c.LiveStartIndex, pls)
+	var offset time.Duration
+	c.curSeqNo, offset = selectCurSeqNo(*c.LiveStartIndex, start, pls)
+	c.OnStart(c.curSeqNo, offset)
 
 	for i := c.curSeqNo - pls.MediaSequence; i < len(pls.Segments); i++ {
 		if err := c.fetchMediaSegment(pls.Segments[i], i); err != nil {
@@ -186,7 +220,7 @@ func (c *HLSPlayer) run() error {
 	for {
 		select {
 		case <-timer.C:
-			pls, err := c.fetchMediaPlaylist(c.playlistURL.String())
+			pls, _, err := c.fetchMediaPlaylist(c.playlistURL.String())
 			if err != nil {
 				slog.Error("fetchMediaPlaylist", "err", err, "url", c.playlistURL.String())
 				return err
@@ -222,26 +256,26 @@ func (c *HLSPlayer) run() error {
 
 }
 
-func (c *HLSPlayer) fetchMediaPlaylist(url string) (*playlist.Media, error) {
+func (c *HLSPlayer) fetchMediaPlaylist(url string) (*playlist.Media, []byte, error) {
 	b, err := fetch(c.ctx, c.HTTPClient, c.OnRequest, url)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	c.lastLoadTimeMillis = time.Now().UnixMilli()
 
 	pl, err := playlist.Unmarshal(b)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	pls, ok := pl.(*playlist.Media)
 	if !ok {
-		return nil, fmt.Errorf("invalid media playlist")
+		return nil, nil, fmt.Errorf("invalid media playlist")
 	}
 
 	c.OnPlaylistDownloaded(b, pls)
 
-	return pls, nil
+	return pls, b, nil
 }
 
 func (c *HLSPlayer) fetchMediaSegment(seg *playlist.MediaSegment, i int) error {
@@ -265,7 +299,18 @@ func (c *HLSPlayer) fetchMediaSegment(seg *playlist.MediaSegment, i int) error {
 If the EXT-X-ENDLIST tag is not present and the client intends to play the media normally, the client
 SHOULD NOT choose a segment that starts less than three target durations from the end of the Playlist file.
 */
-func selectCurSeqNo(liveStartIndex int, pls *playlist.Media) (seqNo int) {
+func selectCurSeqNo(liveStartIndex int, start *StartOffset, pls *playlist.Media) (seqNo int, offset time.Duration) {
+	// If #EXT-X-START in playlist or a start offset, start at the segment of its offset
+	if start != nil && len(pls.Segments) > 0 {
+		timeOffset, ok := start.TimeOffset, true
+		if !start.ProgramDateTime.IsZero() {
+			timeOffset, ok = offsetAtDateTime(pls, start.ProgramDateTime)
+		}
+		if ok {
+			return segmentAtOffset(pls, timeOffset, start.Precise)
+		}
+	}
+
 	if !isVOD(pls) {
 		// If this is a live stream, start live_start_index segments from the start or end
 		if liveStartIndex < 0 {
@@ -273,17 +318,92 @@ func selectCurSeqNo(liveStartIndex int, pls *playlist.Media) (seqNo int) {
 		} else {
 			seqNo = pls.MediaSequence + min(liveStartIndex, len(pls.Segments)-1)
 		}
+		return seqNo, 0
+	}
 
-		// If #EXT-X-START in playlist, need to recalculate
-		if pls.Start != nil {
-			// TODO(Lysander)
+	// Otherwise just start on the first segment
+	return pls.MediaSequence, 0
+}
+
+/*
+[spec 4.3.5.2](https://datatracker.ietf.org/doc/html/rfc8216#section-4.3.5.2)
+If the absolute value of TIME-OFFSET exceeds the duration of the Playlist, it indicates either the end of the
+Playlist (if positive) or the beginning of the Playlist (if negative).
+
+PRECISE: If the value is YES, clients SHOULD start playback at the Media Segment containing the TIME-OFFSET,
+but SHOULD NOT render media samples in that segment whose presentation times are prior to the TIME-OFFSET.
+If the value is NO, clients SHOULD attempt to render every media sample in that segment.
+*/
+func segmentAtOffset(pls *playlist.Media, timeOffset time.Duration, precise bool) (int, time.Duration) {
+	var total time.Duration
+	for _, seg := range pls.Segments {
+		total += seg.Duration
+	}
+	if timeOffset < 0 {
+		timeOffset = max(total+timeOffset, 0)
+	}
+	if timeOffset >= total {
+		return pls.MediaSequence + len(pls.Segments) - 1, 0
+	}
+
+	var segStart time.Duration
+	for i, seg := range pls.Segments {
+		if timeOffset < segStart+seg.Duration {
+			if precise {
+				// Start inside the segment containing the offset
+				return pls.MediaSequence + i, timeOffset - segStart
+			}
+			// Otherwise present the whole segment containing the offset
+			return pls.MediaSequence + i, 0
 		}
+		segStart += seg.Duration
+	}
+	return pls.MediaSequence + len(pls.Segments) - 1, 0
+}
 
-		return seqNo
+// offsetAtDateTime returns the offset of t from the start of the playlist, according to the
+// EXT-X-PROGRAM-DATE-TIME of the segments. It returns false if the playlist has none.
+func offsetAtDateTime(pls *playlist.Media, t time.Time) (time.Duration, bool) {
+	var segStart time.Duration
+	var date *time.Time
+	found := false
+	for _, seg := range pls.Segments {
+		if seg.DateTime != nil {
+			date = seg.DateTime
+		}
+		if date != nil {
+			found = true
+			if t.Before(date.Add(seg.Duration)) {
+				return segStart + max(t.Sub(*date), 0), true
+			}
+			next := date.Add(seg.Duration)
+			date = &next
+		}
+		segStart += seg.Duration
 	}
+	// After the last segment, start at the end
+	return segStart, found
+}
 
-	// Otherwise just start on the first segment
-	return pls.MediaSequence
+// playlistStart returns the start offset of the EXT-X-START tag of a playlist, with its PRECISE attribute
+// that the parsed tag lacks.
+func playlistStart(b []byte, start *playlist.MediaStart) *StartOffset {
+	if start == nil {
+		return nil
+	}
+	s := &StartOffset{TimeOffset: start.TimeOffset}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "#EXT-X-START:"); ok {
+			attrs, err := primitives.AttributesUnmarshal(v)
+			if err == nil {
+				s.Precise = attrs["PRECISE"] == "YES"
+			}
+			break
+		}
+	}
+	return s
 }
 
 func defaultReloadInterval(pls *playlist.Media) time.Duration {
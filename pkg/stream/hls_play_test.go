@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gohlslib/pkg/playlist"
+)
+
+const testMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:100
+%s
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00.000Z
+#EXTINF:4.000,
+100.ts
+#EXTINF:4.000,
+101.ts
+#EXTINF:4.000,
+102.ts
+#EXTINF:4.000,
+103.ts
+#EXTINF:4.000,
+104.ts
+#EXTINF:4.000,
+105.ts
+`
+
+func parseMediaPlaylist(t *testing.T, tags string) ([]byte, *playlist.Media) {
+	t.Helper()
+	b := []byte(fmt.Sprintf(testMediaPlaylist, tags))
+	pl, err := playlist.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, pl.(*playlist.Media)
+}
+
+func TestSelectCurSeqNo(t *testing.T) {
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		tags       string
+		start      *StartOffset
+		wantSeqNo  int
+		wantOffset time.Duration
+	}{
+		{"live", "", nil, 103, 0},
+		{"vod", "#EXT-X-ENDLIST", nil, 100, 0},
+		{"positive offset", "#EXT-X-START:TIME-OFFSET=9", nil, 102, 0},
+		{"containing segment", "#EXT-X-START:TIME-OFFSET=11", nil, 102, 0},
+		{"precise", "#EXT-X-START:TIME-OFFSET=11,PRECISE=YES", nil, 102, 3 * time.Second},
+		{"negative offset", "#EXT-X-START:TIME-OFFSET=-6,PRECISE=YES", nil, 104, 2 * time.Second},
+		{"vod offset", "#EXT-X-START:TIME-OFFSET=5\n#EXT-X-ENDLIST", nil, 101, 0},
+		{"beyond the end", "#EXT-X-START:TIME-OFFSET=60", nil, 105, 0},
+		{"beyond the start", "#EXT-X-START:TIME-OFFSET=-60", nil, 100, 0},
+		{"override", "#EXT-X-START:TIME-OFFSET=-6", &StartOffset{TimeOffset: 1}, 100, 0},
+		{"program date time", "", &StartOffset{ProgramDateTime: date.Add(13 * time.Second), Precise: true}, 103, time.Second},
+		{"program date time before", "", &StartOffset{ProgramDateTime: date.Add(-time.Hour)}, 100, 0},
+		{"program date time after", "", &StartOffset{ProgramDateTime: date.Add(time.Hour)}, 105, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, pls := parseMediaPlaylist(t, tt.tags)
+			start := tt.start
+			if start == nil {
+				start = playlistStart(b, pls.Start)
+			}
+			seqNo, offset := selectCurSeqNo(defaultLiveStartIndex, start, pls)
+			if seqNo != tt.wantSeqNo || offset != tt.wantOffset {
+				t.Errorf("selectCurSeqNo() = %d, %v, want %d, %v", seqNo, offset, tt.wantSeqNo, tt.wantOffset)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 )
@@ -95,4 +96,58 @@ func PKCS7UnPadding(data []byte) ([]byte, error) {
 	return data[:(length - unpadding)], nil
 }
 
+// -------------------------*------------------------- GCM mode -------------------------#-------------------------
+
+// RandomKey returns a random key of size bytes, 16, 24 or 32 for AES-128, AES-192 or AES-256.
+func RandomKey(size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewAESGCM returns an AES-GCM AEAD with key, to be reused by GCMSeal and GCMOpen.
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GCMSeal encrypts and authenticates plaintext and additionalData, with a random nonce prepended to the ciphertext.
+func GCMSeal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// GCMOpen decrypts a ciphertext of GCMSeal, and authenticates it with additionalData.
+func GCMOpen(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func AESEncryptGCM(plaintext, key, additionalData []byte) ([]byte, error) {
+	aead, err := NewAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return GCMSeal(aead, plaintext, additionalData)
+}
+
+func AESDecryptGCM(ciphertext, key, additionalData []byte) ([]byte, error) {
+	aead, err := NewAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return GCMOpen(aead, ciphertext, additionalData)
+}
+
 // -------------------------*------------------------- public key encryption -------------------------#-------------------------
@@ -16,3 +16,31 @@ func TestAESEncryptCBC(t *testing.T) {
 	decrypted := AESDecryptCBC(encrypted, key, iv)
 	t.Log("解密数据:", decrypted)
 }
+
+func TestAESEncryptGCM(t *testing.T) {
+	key, err := RandomKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("666ddrobotwoie878oasnx")
+
+	encrypted, err := AESEncryptGCM(message, key, []byte("header"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := AESDecryptGCM(encrypted, key, []byte("header"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != string(message) {
+		t.Errorf("decrypted = %q, want %q", decrypted, message)
+	}
+
+	if _, err := AESDecryptGCM(encrypted, key, []byte("other")); err == nil {
+		t.Error("decrypted with other additional data")
+	}
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := AESDecryptGCM(encrypted, key, []byte("header")); err == nil {
+		t.Error("decrypted a tampered ciphertext")
+	}
+}
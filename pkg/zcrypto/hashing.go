@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"hash"
 )
 
 // -------------------------*------------------------- Message-Digest Algorithm -------------------------#-------------------------
@@ -58,3 +59,23 @@ func HmacSHA512(s, key string) string {
 	h.Write([]byte(s))
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// -------------------------*------------------------- Raw bytes -------------------------#-------------------------
+
+// SHA256SumBytes returns the hex encoded SHA-256 digest of data.
+func SHA256SumBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HmacSum returns the raw HMAC of data using the given hash function, e.g. sha256.New.
+func HmacSum(h func() hash.Hash, data, key []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// HmacSHA256Bytes returns the raw HMAC-SHA256 of data, for chained key derivations.
+func HmacSHA256Bytes(data, key []byte) []byte {
+	return HmacSum(sha256.New, data, key)
+}
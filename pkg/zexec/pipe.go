@@ -0,0 +1,85 @@
+package zexec
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const pipeCloseTimeout = 5 * time.Second
+
+// Pipe reads the stdout and writes the stdin of a process started by StartPipe,
+// e.g. to talk to it with jsonrpc.NewStreamConn.
+type Pipe struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+
+	// Wait closes stdout, it is called once stdout is read to the end or on Close
+	waitOnce sync.Once
+	done     chan error
+}
+
+// StartPipe starts command with its stdin and stdout piped. The stderr of the process goes to os.Stderr.
+func StartPipe(command string, args ...string) (*Pipe, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &Pipe{cmd: cmd, stdin: stdin, stdout: stdout, done: make(chan error, 1)}, nil
+}
+
+func (p *Pipe) wait() {
+	p.waitOnce.Do(func() {
+		go func() { p.done <- p.cmd.Wait() }()
+	})
+}
+
+func (p *Pipe) Read(b []byte) (int, error) {
+	n, err := p.stdout.Read(b)
+	if err == io.EOF {
+		p.wait()
+	}
+	return n, err
+}
+
+func (p *Pipe) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close closes the stdin of the process and waits for it to exit, killing it if it does not within 5 seconds.
+// A non-zero exit status is not reported as an error.
+func (p *Pipe) Close() error {
+	p.stdin.Close()
+	p.wait()
+
+	var err error
+	select {
+	case err = <-p.done:
+	case <-time.After(pipeCloseTimeout):
+		p.cmd.Process.Kill()
+		err = <-p.done
+	}
+	// keep the result for later calls
+	p.done <- err
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+	return err
+}
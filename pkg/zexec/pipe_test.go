@@ -0,0 +1,53 @@
+package zexec
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+
+	var want bytes.Buffer
+	for i := 1; i <= 100000; i++ {
+		want.WriteString(strconv.Itoa(i) + "\n")
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		input []byte
+	}{
+		// the process exits right after writing, its output must still be read in full
+		{"exits", []string{"-c", "i=1; while [ $i -le 100000 ]; do echo $i; i=$((i+1)); done"}, nil},
+		{"echoes stdin", []string{"-c", "cat"}, want.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := StartPipe("sh", tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() {
+				p.Write(tt.input)
+				p.stdin.Close()
+			}()
+
+			got, err := io.ReadAll(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Errorf("read %d bytes, want %d", len(got), want.Len())
+			}
+			if err := p.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
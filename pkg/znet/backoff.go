@@ -57,6 +57,7 @@ func MaxWaitTime(value time.Duration) Option {
 }
 
 // RetryConditions sets the conditions that will be checked for retry.
+// Without conditions, errors are retried except 4xx status errors other than 429.
 func RetryConditions(conditions []RetryConditionFunc) Option {
 	return func(o *Options) {
 		o.retryConditions = conditions
@@ -73,6 +74,25 @@ func RetryHooks(hooks []OnRetryFunc) Option {
 // Backoff retries with increasing timeout duration up until X amount of retries
 // (Default is 3 attempts, Override with option Retries(n))
 func Backoff(operation func() (*Response, error), options ...Option) error {
+	return backoff(operation, func(resp *Response) context.Context {
+		if resp != nil && resp.Request.ctx != nil {
+			return resp.Request.ctx
+		}
+		return context.Background()
+	}, options)
+}
+
+// BackoffWithContext retries an operation other than a request, as Backoff does.
+// Retry conditions get a nil *Response. It stops waiting when ctx is done.
+func BackoffWithContext(ctx context.Context, operation func() error, options ...Option) error {
+	return backoff(func() (*Response, error) {
+		return nil, operation()
+	}, func(*Response) context.Context {
+		return ctx
+	}, options)
+}
+
+func backoff(operation func() (*Response, error), contextOf func(*Response) context.Context, options []Option) error {
 	// Defaults
 	opts := Options{
 		maxRetries:      defaultMaxRetries,
@@ -92,15 +112,12 @@ func Backoff(operation func() (*Response, error), options ...Option) error {
 
 	for attempt := 0; attempt <= opts.maxRetries; attempt++ {
 		resp, err = operation()
-		ctx := context.Background()
-		if resp != nil && resp.Request.ctx != nil {
-			ctx = resp.Request.ctx
-		}
+		ctx := contextOf(resp)
 		if ctx.Err() != nil {
 			return err
 		}
 
-		needsRetry := err != nil // retry on a few operation errors by default
+		needsRetry := err != nil && !isClientError(err) // retry on a few operation errors by default
 
 		for _, condition := range opts.retryConditions {
 			needsRetry = condition(resp, err)
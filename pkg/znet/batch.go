@@ -0,0 +1,164 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// BatchResult holds the outcome of one request of a batch.
+type BatchResult struct {
+	// Index of the request in the slice passed to Batch
+	Index    int
+	Response *Response
+	Err      error
+}
+
+type (
+	// BatchOption configures Client.Batch
+	BatchOption func(*batchOptions)
+
+	batchOptions struct {
+		failFast     bool
+		onResult     func(BatchResult)
+		retryOptions []Option
+		retry        bool
+	}
+)
+
+// BatchFailFast stops the batch on the first failed request: outstanding requests are canceled
+// and requests that have not been started yet are skipped with a context.Canceled error.
+func BatchFailFast() BatchOption {
+	return func(o *batchOptions) {
+		o.failFast = true
+	}
+}
+
+// BatchOnResult sets a callback invoked as soon as each request completes.
+// Calls are serialized, so the callback does not need to be safe for concurrent use.
+func BatchOnResult(fn func(BatchResult)) BatchOption {
+	return func(o *batchOptions) {
+		o.onResult = fn
+	}
+}
+
+// BatchRetries executes every request of the batch with retries, using the given options.
+func BatchRetries(options ...Option) BatchOption {
+	return func(o *batchOptions) {
+		o.retry = true
+		o.retryOptions = options
+	}
+}
+
+// Batch executes reqs with at most concurrency requests in flight and returns the results in input order.
+// Each request is sent with its own Method and URL.
+//
+// By default, every request is executed and the returned error joins all request errors.
+// With BatchFailFast, the returned error is the first one encountered.
+// Per-host rate limits set by SetHostRateLimit apply to every request.
+func (c *Client) Batch(ctx context.Context, reqs []*Request, concurrency int, opts ...BatchOption) ([]BatchResult, error) {
+	var o batchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	concurrency = min(concurrency, len(reqs))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		results  = make([]BatchResult, len(reqs))
+		indexes  = make(chan int)
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	complete := func(res BatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		results[res.Index] = res
+		if res.Err != nil && firstErr == nil {
+			firstErr = res.Err
+			if o.failFast {
+				cancel()
+			}
+		}
+		if o.onResult != nil {
+			o.onResult(res)
+		}
+	}
+
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				complete(c.batchExecute(ctx, i, reqs[i], &o))
+			}
+		}()
+	}
+
+	for i := range reqs {
+		if ctx.Err() != nil {
+			complete(BatchResult{Index: i, Err: ctx.Err()})
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			complete(BatchResult{Index: i, Err: ctx.Err()})
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if o.failFast {
+		return results, firstErr
+	}
+
+	var errs []error
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+func (c *Client) batchExecute(ctx context.Context, i int, r *Request, o *batchOptions) BatchResult {
+	if err := ctx.Err(); err != nil {
+		return BatchResult{Index: i, Err: err}
+	}
+
+	// Keep the request's own context, but cancel it together with the batch.
+	orig := r.ctx
+	parent := orig
+	if parent == nil {
+		parent = ctx
+	}
+	reqCtx, cancel := context.WithCancel(parent)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	r.client = c
+	r.ctx = reqCtx
+	defer func() { r.ctx = orig }()
+
+	var (
+		resp *Response
+		err  error
+	)
+	if o.retry {
+		resp, err = r.ExecuteWithRetries(r.Method, r.URL, o.retryOptions...)
+	} else {
+		resp, err = r.Send()
+	}
+	return BatchResult{Index: i, Response: resp, Err: err}
+}
@@ -0,0 +1,143 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Batch(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		// answer later requests sooner, so completion order differs from input order
+		i, _ := strconv.Atoi(r.URL.Query().Get("i"))
+		time.Sleep(time.Duration(10-i) * 2 * time.Millisecond)
+		w.Write([]byte(r.URL.Query().Get("i")))
+	}))
+	defer ts.Close()
+
+	client := New()
+	client.BaseURL = ts.URL
+
+	var reqs []*Request
+	for i := 0; i < 10; i++ {
+		reqs = append(reqs, client.R().SetQueryParam("i", strconv.Itoa(i)))
+	}
+
+	var completed atomic.Int32
+	results, err := client.Batch(context.Background(), reqs, 3, BatchOnResult(func(BatchResult) {
+		completed.Add(1)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, res := range results {
+		if res.Index != i || res.Response.String() != strconv.Itoa(i) {
+			t.Errorf("results[%d] = %d %q", i, res.Index, res.Response.String())
+		}
+	}
+	if completed.Load() != 10 {
+		t.Errorf("OnResult called %d times, want 10", completed.Load())
+	}
+	if maxInFlight.Load() > 3 {
+		t.Errorf("max in flight = %d, want <= 3", maxInFlight.Load())
+	}
+}
+
+func TestClient_BatchFailFast(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			panic(http.ErrAbortHandler)
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	client := New()
+	client.BaseURL = ts.URL
+
+	reqs := []*Request{client.R(), client.R(), client.R(), client.R()}
+	reqs[1].URL = "/fail"
+
+	start := time.Now()
+	results, err := client.Batch(context.Background(), reqs, 2, BatchFailFast())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("outstanding requests were not canceled")
+	}
+	for _, res := range results {
+		if res.Err == nil {
+			t.Errorf("request %d: expected an error", res.Index)
+		}
+	}
+	if !errors.Is(results[3].Err, context.Canceled) {
+		t.Errorf("request 3: err = %v, want context.Canceled", results[3].Err)
+	}
+}
+
+func TestClient_SetHostRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client := New()
+	client.BaseURL = ts.URL
+	client.SetHostRateLimit(ts.Listener.Addr().String(), 50, 1)
+
+	reqs := []*Request{client.R(), client.R(), client.R(), client.R(), client.R()}
+	start := time.Now()
+	if _, err := client.Batch(context.Background(), reqs, 5); err != nil {
+		t.Fatal(err)
+	}
+	// the first request uses the burst, the 4 others wait 20ms each
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("elapsed %v, rate limit not honored", elapsed)
+	}
+}
+
+func TestClient_BatchRetries(t *testing.T) {
+	var attempts atomic.Int32
+	queries := make(chan string, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		if attempts.Add(1) == 1 {
+			panic(http.ErrAbortHandler)
+		}
+	}))
+	defer ts.Close()
+
+	client := New()
+	client.BaseURL = ts.URL
+
+	reqs := []*Request{client.R().SetQueryParam("app", "live")}
+	results, err := client.Batch(context.Background(), reqs, 1, BatchRetries(WaitTime(time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || attempts.Load() != 2 {
+		t.Fatalf("err = %v after %d attempts, want success after 2", results[0].Err, attempts.Load())
+	}
+	for range 2 {
+		if q := <-queries; q != "app=live" {
+			t.Errorf("query = %q, want %q", q, "app=live")
+		}
+	}
+}
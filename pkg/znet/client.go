@@ -5,11 +5,13 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
 var (
 	hdrUserAgentKey   = http.CanonicalHeaderKey("User-Agent")
+	hdrContentTypeKey = http.CanonicalHeaderKey("Content-Type")
 	hdrUserAgentValue = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36"
 )
 
@@ -22,13 +24,18 @@ type (
 )
 
 type Client struct {
+	// BaseURL of relative request URLs. A `unix:///path/to/socket` base URL sends requests over a Unix domain socket.
 	BaseURL       string
 	Header        http.Header
 	scheme        string
 	httpClient    *http.Client
 	proxyURL      *url.URL
+	localAddr     net.Addr
 	beforeRequest []RequestMiddleware
 	afterResponse []ResponseMiddleware
+	errorOnStatus bool
+	limitersMu    sync.RWMutex
+	limiters      map[string]*rateLimiter
 }
 
 func (c *Client) SetHeader(header, value string) *Client {
@@ -48,6 +55,18 @@ func (c *Client) SetTimeout(timeout time.Duration) *Client {
 	return c
 }
 
+// OnBeforeRequest appends a request middleware, called after the `http.Request` has been created.
+func (c *Client) OnBeforeRequest(m RequestMiddleware) *Client {
+	c.beforeRequest = append(c.beforeRequest, m)
+	return c
+}
+
+// OnAfterResponse appends a response middleware, called after the response body has been read.
+func (c *Client) OnAfterResponse(m ResponseMiddleware) *Client {
+	c.afterResponse = append(c.afterResponse, m)
+	return c
+}
+
 func New() *Client {
 	return createClient(&http.Client{})
 }
@@ -58,9 +77,11 @@ func NewWithClient(hc *http.Client) *Client {
 
 // NewWithLocalAddr method creates a new client with given Local Address to dial from.
 func NewWithLocalAddr(localAddr net.Addr) *Client {
-	return createClient(&http.Client{
+	c := createClient(&http.Client{
 		Transport: createTransport(localAddr),
 	})
+	c.localAddr = localAddr
+	return c
 }
 
 func (c *Client) R() *Request {
@@ -82,6 +103,10 @@ func (c *Client) execute(req *Request) (response *Response, err error) {
 		}
 	}
 
+	if err = c.waitRateLimit(req); err != nil {
+		return nil, newError(req, classifyError(err), err)
+	}
+
 	req.Time = time.Now()
 	resp, err := c.httpClient.Do(req.RawRequest)
 
@@ -91,22 +116,31 @@ func (c *Client) execute(req *Request) (response *Response, err error) {
 	}
 	response.setReceivedAt()
 
-	if err != nil || req.notParseResponse {
-		return nil, err
+	if err != nil {
+		return nil, newError(req, classifyError(err), err)
+	}
+	if req.notParseResponse {
+		return nil, nil
 	}
 	defer resp.Body.Close()
 
 	response.body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return
+		e := newError(req, CategoryBodyRead, err)
+		e.StatusCode = resp.StatusCode
+		return response, e
 	}
 
 	// Apply Response middleware
 	for _, f := range c.afterResponse {
 		if err = f(c, response); err != nil {
-			break
+			return
 		}
 	}
+
+	if c.errorOnStatus && response.IsError() {
+		err = newStatusError(req, response)
+	}
 	return
 }
 
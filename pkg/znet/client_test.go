@@ -1,8 +1,13 @@
 package znet
 
 import (
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewWithLocalAddr(t *testing.T) {
@@ -32,3 +37,28 @@ func TestBackoff(t *testing.T) {
 
 	t.Log(resp.String())
 }
+
+func TestExecuteWithRetries_ReaderBody(t *testing.T) {
+	const payload = `{"stream":"live/test"}`
+
+	var attempts int
+	var gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts++; attempts == 1 {
+			panic(http.ErrAbortHandler)
+		}
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer ts.Close()
+
+	// the reader is consumed by the first attempt
+	_, err := New().R().SetBody(io.NopCloser(strings.NewReader(payload))).
+		ExecuteWithRetries(http.MethodPost, ts.URL, WaitTime(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || gotBody != payload {
+		t.Errorf("%d attempts, body = %q, want 2, %q", attempts, gotBody, payload)
+	}
+}
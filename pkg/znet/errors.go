@@ -0,0 +1,225 @@
+package znet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+const bodySnippetSize = 512
+
+// ErrorCategory classifies request failures. Categories are errors themselves, so that
+//
+//	errors.Is(err, znet.CategoryTimeout)
+//
+// reports whether err is a *znet.Error of that category.
+type ErrorCategory string
+
+const (
+	CategoryUnknown     ErrorCategory = "unknown"
+	CategoryDNS         ErrorCategory = "dns"
+	CategoryConnRefused ErrorCategory = "connection refused"
+	CategoryTimeout     ErrorCategory = "timeout"
+	CategoryTLS         ErrorCategory = "tls"
+	CategoryHTTPStatus  ErrorCategory = "http status"
+	CategoryBodyRead    ErrorCategory = "body read"
+	CategoryCanceled    ErrorCategory = "canceled"
+)
+
+func (c ErrorCategory) Error() string {
+	return "znet: " + string(c)
+}
+
+// Error is returned by requests that failed, and by requests with a 4xx/5xx response
+// when the client is set with SetErrorOnStatus(true).
+type Error struct {
+	Method string
+	// URL of the request, with credentials and secret query parameters redacted
+	URL     string
+	Attempt int
+	// StatusCode of the response, 0 if no response was received
+	StatusCode int
+	// Body holds the beginning of the response body
+	Body     string
+	Category ErrorCategory
+	// Err is the underlying error, nil for HTTP status errors
+	Err error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", e.Method, e.URL)
+	if e.Attempt > 1 {
+		fmt.Fprintf(&b, " (attempt %d)", e.Attempt)
+	}
+	b.WriteString(": ")
+	if e.Category == CategoryHTTPStatus {
+		fmt.Fprintf(&b, "status %d", e.StatusCode)
+		if e.Body != "" {
+			b.WriteString(": " + e.Body)
+		}
+		return b.String()
+	}
+	b.WriteString(string(e.Category))
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the category of e.
+func (e *Error) Is(target error) bool {
+	c, ok := target.(ErrorCategory)
+	return ok && c == e.Category
+}
+
+// SetErrorOnStatus makes requests with a 4xx/5xx response return a *Error of category CategoryHTTPStatus,
+// along with the response.
+func (c *Client) SetErrorOnStatus(enabled bool) *Client {
+	c.errorOnStatus = enabled
+	return c
+}
+
+// CategoryOf returns the category of a *Error in err's chain, or CategoryUnknown.
+func CategoryOf(err error) ErrorCategory {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	return CategoryUnknown
+}
+
+// RetryOnCategories returns a retry condition that retries errors of the given categories.
+//
+//	client.R().GetWithRetries(url, RetryConditions([]RetryConditionFunc{
+//		RetryOnCategories(CategoryTimeout, CategoryConnRefused),
+//	}))
+func RetryOnCategories(categories ...ErrorCategory) RetryConditionFunc {
+	return func(_ *Response, err error) bool {
+		if err == nil {
+			return false
+		}
+		category := CategoryOf(err)
+		for _, c := range categories {
+			if c == category {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func newError(r *Request, category ErrorCategory, err error) *Error {
+	if urlErr, ok := err.(*url.Error); ok {
+		// the transport error repeats the method and the unredacted URL
+		err = urlErr.Err
+	}
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	return &Error{
+		Method:   method,
+		URL:      redactURL(r.URL),
+		Attempt:  max(r.Attempt, 1),
+		Category: category,
+		Err:      err,
+	}
+}
+
+func newStatusError(r *Request, resp *Response) *Error {
+	e := newError(r, CategoryHTTPStatus, nil)
+	e.StatusCode = resp.StatusCode()
+	e.Body = bodySnippet(resp.body)
+	return e
+}
+
+// classifyError returns the category of a request error.
+func classifyError(err error) ErrorCategory {
+	var (
+		dnsErr        *net.DNSError
+		netErr        net.Error
+		recordErr     tls.RecordHeaderError
+		alertErr      tls.AlertError
+		certErr       *tls.CertificateVerificationError
+		unknownCAErr  x509.UnknownAuthorityError
+		hostnameErr   x509.HostnameError
+		certInvalid   x509.CertificateInvalidError
+		systemRootErr x509.SystemRootsError
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return CategoryCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CategoryTimeout
+	case errors.As(err, &dnsErr):
+		return CategoryDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return CategoryConnRefused
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &certErr),
+		errors.As(err, &unknownCAErr), errors.As(err, &hostnameErr), errors.As(err, &certInvalid),
+		errors.As(err, &systemRootErr):
+		return CategoryTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return CategoryTimeout
+	}
+	return CategoryUnknown
+}
+
+// isClientError reports whether err is a 4xx status error other than 429 Too Many Requests,
+// which a retry would fail the same way.
+func isClientError(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) || e.Category != CategoryHTTPStatus {
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests
+}
+
+func bodySnippet(body []byte) string {
+	if len(body) > bodySnippetSize {
+		body = body[:bodySnippetSize]
+	}
+	return strings.ToValidUTF8(strings.TrimSpace(string(body)), "")
+}
+
+// redactURL hides the password and the values of query parameters that look like secrets.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k := range query {
+			if isSecretParam(k) {
+				query.Set(k, "xxxxx")
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+func isSecretParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"secret", "token", "key", "sign", "auth", "password", "passwd", "credential", "session"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
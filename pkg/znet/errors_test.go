@@ -0,0 +1,161 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestError_Categories(t *testing.T) {
+	// a closed port
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	refused := "http://" + l.Addr().String()
+	l.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		url     string
+		ctx     context.Context
+		timeout time.Duration
+		want    ErrorCategory
+	}{
+		{name: "dns", url: "http://zephyr.invalid/", want: CategoryDNS},
+		{name: "refused", url: refused, want: CategoryConnRefused},
+		{name: "timeout", url: slow.URL, timeout: 50 * time.Millisecond, want: CategoryTimeout},
+		{name: "tls", url: tlsServer.URL, want: CategoryTLS},
+		{name: "canceled", url: slow.URL, ctx: canceled, want: CategoryCanceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := New()
+			if tt.timeout > 0 {
+				client.SetTimeout(tt.timeout)
+			}
+			r := client.R()
+			if tt.ctx != nil {
+				r.SetContext(tt.ctx)
+			}
+
+			_, err := r.Get(tt.url)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if e.Category != tt.want || !errors.Is(err, tt.want) {
+				t.Errorf("category = %q, want %q (%v)", e.Category, tt.want, err)
+			}
+			if e.Method != http.MethodGet || e.Attempt != 1 {
+				t.Errorf("method = %q, attempt = %d", e.Method, e.Attempt)
+			}
+		})
+	}
+}
+
+func TestClient_SetErrorOnStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer ts.Close()
+
+	client := New()
+	resp, err := client.R().Get(ts.URL)
+	if err != nil || resp.StatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("default: %v, %v", resp, err)
+	}
+
+	client.SetErrorOnStatus(true)
+	resp, err = client.R().
+		SetQueryParam("txSecret", "s3cr3t").
+		SetQueryParam("app", "live").
+		GetWithRetries(ts.URL+"/path", Retries(1), WaitTime(time.Millisecond), RetryConditions([]RetryConditionFunc{
+			RetryOnCategories(CategoryHTTPStatus),
+		}))
+
+	var e *Error
+	if !errors.As(err, &e) || resp == nil {
+		t.Fatalf("err = %v, resp = %v", err, resp)
+	}
+	if e.StatusCode != http.StatusServiceUnavailable || len(e.Body) != bodySnippetSize || e.Attempt != 2 {
+		t.Errorf("status = %d, body %d bytes, attempt = %d", e.StatusCode, len(e.Body), e.Attempt)
+	}
+	want := fmt.Sprintf("GET %s/path?app=live&txSecret=xxxxx (attempt 2): status 503: %s", ts.URL, strings.Repeat("x", bodySnippetSize))
+	if err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
+
+func TestError_RedactedTransportError(t *testing.T) {
+	// a closed port
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err := New().R().SetQueryParam("txSecret", "s3cr3t").Get("http://user:pass@" + addr + "/?app=live")
+	redacted := "http://user:xxxxx@" + addr + "/?app=live&txSecret=xxxxx"
+	want := fmt.Sprintf(`GET %s: connection refused: dial tcp %s: connect: connection refused`, redacted, addr)
+	if err == nil || err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("err = %v, want %v in the chain", err, syscall.ECONNREFUSED)
+	}
+}
+
+func TestClient_NoRetryOnClientError(t *testing.T) {
+	tests := []struct {
+		status       int
+		wantAttempts int
+	}{
+		{http.StatusNotFound, 1},
+		{http.StatusTooManyRequests, 2},
+		{http.StatusBadGateway, 2},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			attempts := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			_, err := New().SetErrorOnStatus(true).R().GetWithRetries(ts.URL, Retries(1), WaitTime(time.Millisecond))
+			if CategoryOf(err) != CategoryHTTPStatus || attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, err = %v, want %d", attempts, err, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestError_DefaultMethod(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	r := New().R()
+	r.URL = "http://" + addr
+	_, err := r.Send()
+	want := fmt.Sprintf("GET http://%s: connection refused: dial tcp %s: connect: connection refused", addr, addr)
+	if err == nil || err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
@@ -1,6 +1,10 @@
 package znet
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -25,6 +29,11 @@ func parseRequestURL(c *Client, r *Request) error {
 		}
 
 		baseURL := c.BaseURL
+		if socket, ok := unixSocketPath(baseURL); ok {
+			// Requests are sent over the socket, the host keys the pooled connections of the socket
+			baseURL = "http://" + unixSocketHost(socket)
+			r.unixSocket = socket
+		}
 		reqURL, err = url.Parse(baseURL + r.URL)
 		if err != nil {
 			return err
@@ -72,7 +81,12 @@ func parseRequestHeader(c *Client, r *Request) error {
 }
 
 func createHTTPRequest(c *Client, r *Request) (err error) {
-	r.RawRequest, err = http.NewRequest(r.Method, r.URL, nil)
+	body, err := requestBodyReader(r)
+	if err != nil {
+		return
+	}
+
+	r.RawRequest, err = http.NewRequest(r.Method, r.URL, body)
 
 	if err != nil {
 		return
@@ -86,9 +100,52 @@ func createHTTPRequest(c *Client, r *Request) (err error) {
 		r.RawRequest = r.RawRequest.WithContext(r.ctx)
 	}
 
+	if r.unixSocket != "" {
+		ctx := context.WithValue(r.RawRequest.Context(), unixSocketKey{}, r.unixSocket)
+		r.RawRequest = r.RawRequest.WithContext(ctx)
+		r.RawRequest.Host = "localhost"
+	}
+
 	return
 }
 
+func requestBodyReader(r *Request) (io.Reader, error) {
+	switch body := r.Body.(type) {
+	case nil:
+		if len(r.FormData) == 0 {
+			return nil, nil
+		}
+		if IsStringEmpty(r.Header.Get(hdrContentTypeKey)) {
+			r.Header.Set(hdrContentTypeKey, "application/x-www-form-urlencoded")
+		}
+		return strings.NewReader(r.FormData.Encode()), nil
+	case []byte:
+		return bytes.NewReader(body), nil
+	case string:
+		return strings.NewReader(body), nil
+	case io.Reader:
+		// read once, retries send the same bytes
+		b, err := io.ReadAll(body)
+		if c, ok := body.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Body = b
+		return bytes.NewReader(b), nil
+	default:
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		if IsStringEmpty(r.Header.Get(hdrContentTypeKey)) {
+			r.Header.Set(hdrContentTypeKey, "application/json")
+		}
+		return bytes.NewReader(b), nil
+	}
+}
+
 func IsStringEmpty(str string) bool {
 	return len(strings.TrimSpace(str)) == 0
 }
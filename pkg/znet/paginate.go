@@ -0,0 +1,342 @@
+package znet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const defaultMaxPages = 100
+
+// ErrPageLimit is yielded by Paginate when more pages are available than allowed by PageMax.
+var ErrPageLimit = errors.New("znet: page limit reached")
+
+type (
+	// PageOption configures Paginate
+	PageOption func(*pageOptions)
+
+	pageOptions struct {
+		pager        pager
+		itemsPath    string
+		maxPages     int
+		retry        bool
+		retryOptions []Option
+		prepare      func(*Request)
+	}
+
+	// pager moves from one page to the next. Pagers hold no state, an iterator can be ranged over concurrently.
+	pager interface {
+		// first prepares the request of the first page.
+		first(r *Request)
+		// next prepares r for the page following resp, which held n items. It returns false if there are no more pages.
+		next(r *Request, resp *Response, n int) bool
+	}
+)
+
+// PageLinkHeader follows the RFC 8288 `Link: <...>; rel="next"` response header. This is the default.
+func PageLinkHeader() PageOption {
+	return func(o *pageOptions) {
+		o.pager = linkPager{}
+	}
+}
+
+// PageCursor reads the next cursor from the JSON response body at cursorPath (gjson syntax),
+// and sends it back as the query parameter param. Pagination ends when the cursor is empty.
+func PageCursor(param, cursorPath string) PageOption {
+	return func(o *pageOptions) {
+		o.pager = cursorPager{param: param, path: cursorPath}
+	}
+}
+
+// PageOffset pages with offset/limit query parameters. Pagination ends on a page holding less than limit items.
+func PageOffset(offsetParam, limitParam string, limit int) PageOption {
+	return func(o *pageOptions) {
+		o.pager = offsetPager{offsetParam: offsetParam, limitParam: limitParam, limit: limit}
+	}
+}
+
+// PageItems sets the path (gjson syntax) of the items array in the response body.
+// By default, the body itself is expected to be an array.
+func PageItems(path string) PageOption {
+	return func(o *pageOptions) {
+		o.itemsPath = path
+	}
+}
+
+// PageMax sets the max number of pages to fetch (Default is 100), n <= 0 removes the limit.
+func PageMax(n int) PageOption {
+	return func(o *pageOptions) {
+		o.maxPages = n
+	}
+}
+
+// PageRetries fetches every page with retries, using the given options.
+func PageRetries(options ...Option) PageOption {
+	return func(o *pageOptions) {
+		o.retry = true
+		o.retryOptions = options
+	}
+}
+
+// PageRequest sets a function called on the request of every page before it is sent,
+// e.g. to set headers or extra query parameters.
+func PageRequest(fn func(*Request)) PageOption {
+	return func(o *pageOptions) {
+		o.prepare = fn
+	}
+}
+
+// Paginate returns an iterator over the items of a paged JSON API, starting at url.
+// Each item is decoded into T. Iteration stops after the first error, which is yielded with a zero T.
+//
+//	for user, err := range znet.Paginate[User](ctx, client, "/users", znet.PageItems("data")) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func Paginate[T any](ctx context.Context, c *Client, url string, opts ...PageOption) iter.Seq2[T, error] {
+	o := pageOptions{
+		pager:    linkPager{},
+		maxPages: defaultMaxPages,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		r := c.R()
+		r.URL = url
+		o.pager.first(r)
+
+		for page := 0; ; page++ {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			if o.maxPages > 0 && page == o.maxPages {
+				yield(zero, ErrPageLimit)
+				return
+			}
+
+			resp, err := o.fetch(ctx, r)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			items := gjson.ParseBytes(resp.Body())
+			if o.itemsPath != "" {
+				items = gjson.GetBytes(resp.Body(), o.itemsPath)
+			}
+			if items.Exists() && !items.IsArray() {
+				yield(zero, fmt.Errorf("znet: paginate %s: items are not an array", r.URL))
+				return
+			}
+
+			n := 0
+			for _, raw := range items.Array() {
+				n++
+				var item T
+				if err := json.Unmarshal([]byte(raw.Raw), &item); err != nil {
+					yield(zero, err)
+					return
+				}
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			next := c.R()
+			next.URL = url
+			if !o.pager.next(next, resp, n) {
+				return
+			}
+			r = next
+		}
+	}
+}
+
+func (o *pageOptions) fetch(ctx context.Context, r *Request) (*Response, error) {
+	r.SetContext(ctx)
+	if o.prepare != nil {
+		o.prepare(r)
+	}
+
+	var (
+		resp *Response
+		err  error
+	)
+	if o.retry {
+		resp, err = r.ExecuteWithRetries(r.Method, r.URL, o.retryOptions...)
+	} else {
+		resp, err = r.Send()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccess() {
+		return nil, newStatusError(r, resp)
+	}
+	return resp, nil
+}
+
+type linkPager struct{}
+
+func (linkPager) first(*Request) {}
+
+func (linkPager) next(r *Request, resp *Response, _ int) bool {
+	next, ok := ParseLinkHeader(resp.RawResponse.Header.Values("Link"))["next"]
+	if !ok {
+		return false
+	}
+
+	u, err := url.Parse(next)
+	if err != nil {
+		return false
+	}
+	if resp.RawResponse.Request != nil {
+		u = resp.RawResponse.Request.URL.ResolveReference(u)
+	}
+	r.URL = u.String()
+	return true
+}
+
+type cursorPager struct {
+	param string
+	path  string
+}
+
+func (p cursorPager) first(*Request) {}
+
+func (p cursorPager) next(r *Request, resp *Response, _ int) bool {
+	cursor := gjson.GetBytes(resp.Body(), p.path).String()
+	if cursor == "" {
+		return false
+	}
+	r.SetQueryParams(queryParams(resp.Request.QueryParam))
+	r.SetQueryParam(p.param, cursor)
+	return true
+}
+
+type offsetPager struct {
+	offsetParam string
+	limitParam  string
+	limit       int
+}
+
+func (p offsetPager) first(r *Request) {
+	p.setQueryParams(r, 0)
+}
+
+func (p offsetPager) next(r *Request, resp *Response, n int) bool {
+	if n == 0 || n < p.limit {
+		return false
+	}
+	// the offset of the page following resp, from the offset of its request
+	offset, _ := strconv.Atoi(resp.Request.QueryParam.Get(p.offsetParam))
+	r.SetQueryParams(queryParams(resp.Request.QueryParam))
+	p.setQueryParams(r, offset+n)
+	return true
+}
+
+func (p offsetPager) setQueryParams(r *Request, offset int) {
+	r.SetQueryParam(p.offsetParam, strconv.Itoa(offset))
+	r.SetQueryParam(p.limitParam, strconv.Itoa(p.limit))
+}
+
+func queryParams(values url.Values) map[string]string {
+	params := make(map[string]string, len(values))
+	for k := range values {
+		params[k] = values.Get(k)
+	}
+	return params
+}
+
+// ParseLinkHeader parses RFC 8288 Link header values and returns the target URIs by relation type.
+//
+//	Link: <https://api.example.com/items?page=2>; rel="next", <https://api.example.com/items?page=5>; rel="last"
+func ParseLinkHeader(values []string) map[string]string {
+	links := make(map[string]string)
+	for _, v := range values {
+		for len(v) > 0 {
+			v = strings.TrimLeft(v, " ,")
+			if len(v) == 0 || v[0] != '<' {
+				break
+			}
+			end := strings.IndexByte(v, '>')
+			if end < 0 {
+				break
+			}
+			target := v[1:end]
+			v = v[end+1:]
+
+			// link-params, up to the next link-value
+			var rels []string
+			for {
+				v = strings.TrimLeft(v, " ")
+				if len(v) == 0 || v[0] != ';' {
+					break
+				}
+				v = strings.TrimLeft(v[1:], " ")
+
+				var name, value string
+				name, v = splitToken(v, "=;,")
+				if len(v) > 0 && v[0] == '=' {
+					value, v = parseParamValue(v[1:])
+				}
+				if strings.EqualFold(strings.TrimSpace(name), "rel") {
+					rels = strings.Fields(value)
+				}
+			}
+
+			for _, rel := range rels {
+				rel = strings.ToLower(rel)
+				if _, ok := links[rel]; !ok {
+					links[rel] = target
+				}
+			}
+		}
+	}
+	return links
+}
+
+func splitToken(s, stop string) (token, rest string) {
+	i := strings.IndexAny(s, stop)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+func parseParamValue(s string) (value, rest string) {
+	s = strings.TrimLeft(s, " ")
+	if len(s) == 0 || s[0] != '"' {
+		return splitToken(s, ";,")
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
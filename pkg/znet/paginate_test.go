@@ -0,0 +1,168 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type pageItem struct {
+	ID int `json:"id"`
+}
+
+func pageServer() *httptest.Server {
+	const total = 7
+	items := func(from, to int) string {
+		s := "["
+		for i := from; i < min(to, total); i++ {
+			if i > from {
+				s += ","
+			}
+			s += fmt.Sprintf(`{"id":%d}`, i)
+		}
+		return s + "]"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if (page+1)*3 < total {
+			w.Header().Add("Link", fmt.Sprintf(`</link?page=%d>; rel="next", </link?page=2>; rel="last"`, page+1))
+		}
+		w.Write([]byte(items(page*3, page*3+3)))
+	})
+	mux.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		next := ""
+		if from+3 < total {
+			next = strconv.Itoa(from + 3)
+		}
+		fmt.Fprintf(w, `{"data":%s,"meta":{"next":%q}}`, items(from, from+3), next)
+	})
+	mux.HandleFunc("/offset", func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		w.Write([]byte(items(offset, offset+limit)))
+	})
+	return httptest.NewServer(mux)
+}
+
+func collectIDs(t *testing.T, seq func(func(pageItem, error) bool)) ([]int, error) {
+	t.Helper()
+	var ids []int
+	for item, err := range seq {
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+func TestPaginate(t *testing.T) {
+	ts := pageServer()
+	defer ts.Close()
+
+	client := New()
+	client.BaseURL = ts.URL
+	want := []int{0, 1, 2, 3, 4, 5, 6}
+
+	tests := []struct {
+		name string
+		url  string
+		opts []PageOption
+	}{
+		{name: "link", url: "/link"},
+		{name: "cursor", url: "/cursor", opts: []PageOption{PageItems("data"), PageCursor("cursor", "meta.next")}},
+		{name: "offset", url: "/offset", opts: []PageOption{PageOffset("offset", "limit", 3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := collectIDs(t, Paginate[pageItem](context.Background(), client, tt.url, tt.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, want) {
+				t.Errorf("got %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestPaginate_Nested(t *testing.T) {
+	ts := pageServer()
+	defer ts.Close()
+
+	client := New()
+	client.BaseURL = ts.URL
+
+	// each range pages on its own
+	seq := Paginate[pageItem](context.Background(), client, "/offset", PageOffset("offset", "limit", 3))
+	var pairs int
+	for outer, err := range seq {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := collectIDs(t, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids, []int{0, 1, 2, 3, 4, 5, 6}) {
+			t.Fatalf("nested in %d: got %v", outer.ID, ids)
+		}
+		pairs++
+	}
+	if pairs != 7 {
+		t.Errorf("outer range got %d items, want 7", pairs)
+	}
+}
+
+func TestPaginate_Limits(t *testing.T) {
+	ts := pageServer()
+	defer ts.Close()
+
+	client := New()
+	client.BaseURL = ts.URL
+
+	ids, err := collectIDs(t, Paginate[pageItem](context.Background(), client, "/link", PageMax(2)))
+	if !errors.Is(err, ErrPageLimit) || len(ids) != 6 {
+		t.Errorf("PageMax: got %v, %v", ids, err)
+	}
+	ids, err = collectIDs(t, Paginate[pageItem](context.Background(), client, "/link", PageMax(0)))
+	if err != nil || len(ids) != 7 {
+		t.Errorf("PageMax(0): got %v, %v", ids, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, err = range Paginate[pageItem](ctx, client, "/link") {
+		if err != nil {
+			break
+		}
+		cancel()
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: got %v", err)
+	}
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	links := ParseLinkHeader([]string{
+		`<https://api.example.com/items?page=2&a=1,2>; rel="next", <https://api.example.com/items?page=9>; rel=last`,
+		`<https://api.example.com/items?page=1>; title="a;b"; rel="prev first"`,
+	})
+	want := map[string]string{
+		"next":  "https://api.example.com/items?page=2&a=1,2",
+		"last":  "https://api.example.com/items?page=9",
+		"prev":  "https://api.example.com/items?page=1",
+		"first": "https://api.example.com/items?page=1",
+	}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("got %v, want %v", links, want)
+	}
+}
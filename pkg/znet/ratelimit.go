@@ -0,0 +1,103 @@
+package znet
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket, refilled at `rate` tokens per second up to `burst`.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes one token and returns how long the caller has to wait before using it.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel gives back a token that was reserved but not used.
+func (l *rateLimiter) cancel() {
+	l.mu.Lock()
+	l.tokens = min(l.burst, l.tokens+1)
+	l.mu.Unlock()
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	d := l.reserve()
+	if d == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// SetHostRateLimit limits requests to the given host ("host" or "host:port") to rps requests per second,
+// allowing bursts of up to burst requests. A non-positive rps removes the limit.
+func (c *Client) SetHostRateLimit(host string, rps float64, burst int) *Client {
+	c.limitersMu.Lock()
+	defer c.limitersMu.Unlock()
+
+	if rps <= 0 {
+		delete(c.limiters, host)
+		return c
+	}
+	if c.limiters == nil {
+		c.limiters = make(map[string]*rateLimiter)
+	}
+	c.limiters[host] = newRateLimiter(rps, burst)
+	return c
+}
+
+func (c *Client) waitRateLimit(r *Request) error {
+	if r.RawRequest == nil || r.RawRequest.URL == nil {
+		return nil
+	}
+
+	c.limitersMu.RLock()
+	l, ok := c.limiters[r.RawRequest.URL.Host]
+	if !ok {
+		l, ok = c.limiters[r.RawRequest.URL.Hostname()]
+	}
+	c.limitersMu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	return l.wait(r.RawRequest.Context())
+}
@@ -14,12 +14,14 @@ type Request struct {
 	QueryParam       url.Values
 	FormData         url.Values
 	Header           http.Header
+	Body             any
 	Time             time.Time
 	Attempt          int
 	RawRequest       *http.Request
 	ctx              context.Context
 	client           *Client
 	notParseResponse bool
+	unixSocket       string
 }
 
 func (r *Request) SetContext(ctx context.Context) *Request {
@@ -69,6 +71,14 @@ func (r *Request) SetQueryParams(params map[string]string) *Request {
 	return r
 }
 
+// SetBody method sets the request body. Supported types are `[]byte`, `string` and `io.Reader`,
+// any other value is marshaled to JSON with the `Content-Type: application/json` header.
+// An `io.Reader` is read into memory when the request is sent, so that retries send the same body.
+func (r *Request) SetBody(body any) *Request {
+	r.Body = body
+	return r
+}
+
 func (r *Request) SetFormData(data map[string]string) *Request {
 	for k, v := range data {
 		r.FormData.Set(k, v)
@@ -90,6 +100,21 @@ func (r *Request) Head(url string) (*Response, error) {
 	return r.Execute(http.MethodHead, url)
 }
 
+// Post method does POST HTTP request. It's defined in section 4.3.3 of RFC7231.
+func (r *Request) Post(url string) (*Response, error) {
+	return r.Execute(http.MethodPost, url)
+}
+
+// Put method does PUT HTTP request. It's defined in section 4.3.4 of RFC7231.
+func (r *Request) Put(url string) (*Response, error) {
+	return r.Execute(http.MethodPut, url)
+}
+
+// Delete method does DELETE HTTP request. It's defined in section 4.3.5 of RFC7231.
+func (r *Request) Delete(url string) (*Response, error) {
+	return r.Execute(http.MethodDelete, url)
+}
+
 func (r *Request) Send() (*Response, error) {
 	return r.Execute(r.Method, r.URL)
 }
@@ -107,11 +132,12 @@ func (r *Request) Execute(method, url string) (resp *Response, err error) {
 
 func (r *Request) ExecuteWithRetries(method, url string, options ...Option) (resp *Response, err error) {
 	r.Method = method
-	r.URL = url
 
 	err = Backoff(
 		func() (*Response, error) {
 			r.Attempt++
+			// the URL is resolved in place by parseRequestURL, start each attempt from the original
+			r.URL = url
 
 			resp, err = r.client.execute(r)
 			if err != nil {
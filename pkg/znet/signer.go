@@ -0,0 +1,362 @@
+package znet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lysander66/zephyr/pkg/zcrypto"
+)
+
+// Signer signs an outgoing request, usually by adding an Authorization header.
+// body is a copy of the request body, the request body itself is left unread.
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// SignerFunc is an adapter to allow the use of ordinary functions as Signer.
+type SignerFunc func(req *http.Request, body []byte) error
+
+func (f SignerFunc) Sign(req *http.Request, body []byte) error {
+	return f(req, body)
+}
+
+// SignerMiddleware returns a request middleware that signs every request with s.
+func SignerMiddleware(s Signer) RequestMiddleware {
+	return func(c *Client, r *Request) error {
+		if r.RawRequest == nil {
+			return fmt.Errorf("znet: sign: no http request")
+		}
+		body, err := peekBody(r.RawRequest)
+		if err != nil {
+			return err
+		}
+		return s.Sign(r.RawRequest, body)
+	}
+}
+
+// SetSigner signs every request of the client with s.
+func (c *Client) SetSigner(s Signer) *Client {
+	return c.OnBeforeRequest(SignerMiddleware(s))
+}
+
+// peekBody returns a copy of the request body without consuming it.
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	// The body can only be read once: buffer it and make it replayable.
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.ContentLength = int64(len(b))
+	return b, nil
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// AWS Signature Version 4
+//_______________________________________________________________________
+
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+// SigV4Signer signs requests with AWS Signature Version 4.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+	// DisableDoubleEncoding encodes the path segments of the canonical URI once, as S3 does.
+	// Other services encode them twice. It is implied by the "s3" service.
+	DisableDoubleEncoding bool
+	// Now returns the signing time, defaults to time.Now
+	Now func() time.Time
+}
+
+func (s *SigV4Signer) Sign(req *http.Request, body []byte) error {
+	t := signingTime(s.Now)
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	payloadHash := zcrypto.SHA256SumBytes(body)
+	if s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	headers, signedHeaders := canonicalHeaders(req, func(name string) bool {
+		return name == "host" || name == "content-type" || name == "content-md5" || strings.HasPrefix(name, "x-amz-")
+	})
+
+	uri := canonicalURI(req.URL)
+	if !s.DisableDoubleEncoding && s.Service != "s3" {
+		uri = uriEncodePath(uri)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		canonicalQuery(req.URL),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + zcrypto.SHA256Sum(canonicalRequest)
+
+	key := zcrypto.HmacSHA256Bytes([]byte(date), []byte("AWS4"+s.SecretAccessKey))
+	key = zcrypto.HmacSHA256Bytes([]byte(s.Region), key)
+	key = zcrypto.HmacSHA256Bytes([]byte(s.Service), key)
+	key = zcrypto.HmacSHA256Bytes([]byte("aws4_request"), key)
+	signature := hex.EncodeToString(zcrypto.HmacSHA256Bytes([]byte(stringToSign), key))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// Tencent Cloud API 3.0 signature
+//_______________________________________________________________________
+
+const tc3Algorithm = "TC3-HMAC-SHA256"
+
+// TC3Signer signs requests with Tencent Cloud TC3-HMAC-SHA256.
+// The X-TC-Action, X-TC-Version and X-TC-Region headers are set by the caller.
+// https://www.tencentcloud.com/document/api/213/33224
+type TC3Signer struct {
+	SecretID  string
+	SecretKey string
+	// Service is the product name, e.g. "live" or "cvm"
+	Service string
+	// Now returns the signing time, defaults to time.Now
+	Now func() time.Time
+}
+
+func (s *TC3Signer) Sign(req *http.Request, body []byte) error {
+	t := signingTime(s.Now)
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	date := t.Format("2006-01-02")
+
+	req.Header.Set("X-TC-Timestamp", timestamp)
+
+	headers, signedHeaders := canonicalHeaders(req, func(name string) bool {
+		return name == "host" || name == "content-type"
+	})
+
+	// TC3 uses the query string as sent, and lowercase header values.
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		req.URL.RawQuery,
+		strings.ToLower(headers),
+		signedHeaders,
+		zcrypto.SHA256SumBytes(body),
+	}, "\n")
+
+	scope := date + "/" + s.Service + "/tc3_request"
+	stringToSign := tc3Algorithm + "\n" + timestamp + "\n" + scope + "\n" + zcrypto.SHA256Sum(canonicalRequest)
+
+	key := zcrypto.HmacSHA256Bytes([]byte(date), []byte("TC3"+s.SecretKey))
+	key = zcrypto.HmacSHA256Bytes([]byte(s.Service), key)
+	key = zcrypto.HmacSHA256Bytes([]byte("tc3_request"), key)
+	signature := hex.EncodeToString(zcrypto.HmacSHA256Bytes([]byte(stringToSign), key))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		tc3Algorithm, s.SecretID, scope, signedHeaders, signature))
+	return nil
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// Generic HMAC header signature
+//_______________________________________________________________________
+
+// HMACSigner puts an HMAC of the request in a header.
+//
+// By default, the signed string is
+//
+//	METHOD + "\n" + path?query + "\n" + timestamp + "\n" + hex(sha256(body))
+//
+// and the signature is hex encoded HMAC-SHA256, set as the Authorization header.
+type HMACSigner struct {
+	Key []byte
+	// Hash defaults to sha256.New
+	Hash func() hash.Hash
+	// Header receiving the signature, defaults to "Authorization"
+	Header string
+	// Prefix is prepended to the signature in the header value, e.g. "HMAC-SHA256 "
+	Prefix string
+	// TimestampHeader, if set, receives the unix timestamp of the request before it is signed
+	TimestampHeader string
+	// Base64 encodes the signature with standard base64 instead of hex
+	Base64 bool
+	// StringToSign overrides the signed string
+	StringToSign func(req *http.Request, body []byte) string
+	// Now returns the signing time, defaults to time.Now
+	Now func() time.Time
+}
+
+func (s *HMACSigner) Sign(req *http.Request, body []byte) error {
+	timestamp := strconv.FormatInt(signingTime(s.Now).Unix(), 10)
+	if s.TimestampHeader != "" {
+		req.Header.Set(s.TimestampHeader, timestamp)
+	}
+
+	var stringToSign string
+	if s.StringToSign != nil {
+		stringToSign = s.StringToSign(req, body)
+	} else {
+		stringToSign = req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + zcrypto.SHA256SumBytes(body)
+	}
+
+	h := s.Hash
+	if h == nil {
+		h = sha256.New
+	}
+	mac := zcrypto.HmacSum(h, []byte(stringToSign), s.Key)
+
+	signature := hex.EncodeToString(mac)
+	if s.Base64 {
+		signature = base64.StdEncoding.EncodeToString(mac)
+	}
+
+	header := s.Header
+	if header == "" {
+		header = "Authorization"
+	}
+	req.Header.Set(header, s.Prefix+signature)
+	return nil
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// Canonical request helpers
+//_______________________________________________________________________
+
+func signingTime(now func() time.Time) time.Time {
+	if now == nil {
+		return time.Now().UTC()
+	}
+	return now().UTC()
+}
+
+// canonicalHeaders returns the "name:value\n" lines and the ";" joined names of the selected headers,
+// sorted by lowercase name. The host header is taken from the request.
+func canonicalHeaders(req *http.Request, include func(name string) bool) (headers, signedHeaders string) {
+	values := map[string]string{}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if !include(name) {
+			continue
+		}
+		trimmed := make([]string, len(v))
+		for i := range v {
+			trimmed[i] = strings.Join(strings.Fields(v[i]), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+	if include("host") {
+		host := req.Host
+		if host == "" {
+			host = req.URL.Host
+		}
+		values["host"] = host
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return uriEncodePath(u.Path)
+}
+
+// uriEncodePath encodes each segment of path with uriEncode.
+func uriEncodePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = uriEncode(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query parameters encoded and sorted by name, then value.
+func canonicalQuery(u *url.URL) string {
+	var pairs [][2]string
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			pairs = append(pairs, [2]string{uriEncode(k), uriEncode(v)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	query := make([]string, len(pairs))
+	for i, p := range pairs {
+		query[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(query, "&")
+}
+
+// uriEncode percent-encodes everything but the RFC 3986 unreserved characters.
+func uriEncode(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
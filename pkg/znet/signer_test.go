@@ -0,0 +1,172 @@
+package znet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lysander66/zephyr/pkg/zcrypto"
+)
+
+// https://github.com/awslabs/aws-c-auth/tree/main/tests/aws-signing-test-suite/v4
+func TestSigV4Signer(t *testing.T) {
+	signer := &SigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		Now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		// single encodes the path once, as for S3
+		single bool
+		want   string
+	}{
+		{
+			name:   "get-vanilla",
+			method: http.MethodGet,
+			url:    "https://example.amazonaws.com/",
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: http.MethodGet,
+			url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:        "post-x-www-form-urlencoded",
+			method:      http.MethodPost,
+			url:         "https://example.amazonaws.com/",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "Param1=value1",
+			want:        "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			// the canonical URI is /example%2520space/
+			name:   "get-space",
+			method: http.MethodGet,
+			url:    "https://example.amazonaws.com/example%20space/",
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=446b817944c553435b35e813c261ff4e161fff982d1bacdef1c87f6785dd1662",
+		},
+		{
+			name:   "get-space-single-encoding",
+			method: http.MethodGet,
+			url:    "https://example.amazonaws.com/example%20space/",
+			single: true,
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			signer := *signer
+			signer.DisableDoubleEncoding = tt.single
+			if err := signer.Sign(req, []byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// https://www.tencentcloud.com/document/api/213/33224
+// The published example was computed with the masked credentials as they appear in the document.
+func TestTC3Signer(t *testing.T) {
+	signer := &TC3Signer{
+		SecretID:  "AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******",
+		SecretKey: "Gu5t9xGARNpq86cd98joQYCN3*******",
+		Service:   "cvm",
+		Now: func() time.Time {
+			return time.Unix(1551113065, 0)
+		},
+	}
+
+	body := `{"Limit": 1, "Filters": [{"Values": ["\u672a\u547d\u540d"], "Name": "instance-name"}]}`
+	req, _ := http.NewRequest(http.MethodPost, "https://cvm.tencentcloudapi.com/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := signer.Sign(req, []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "TC3-HMAC-SHA256 Credential=AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******/2019-02-25/cvm/tc3_request, SignedHeaders=content-type;host, Signature=2230eefd229f582d8b1b891af7107b91597240707d778ab3738f756258d7652c"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %v, want %v", got, want)
+	}
+	if got := req.Header.Get("X-TC-Timestamp"); got != "1551113065" {
+		t.Errorf("X-TC-Timestamp = %v", got)
+	}
+}
+
+func TestClient_SetSigner(t *testing.T) {
+	const payload = `{"stream":"live/test"}`
+
+	var gotBody, gotSignature string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotSignature = r.Header.Get("X-Signature")
+	}))
+	defer ts.Close()
+
+	signer := &HMACSigner{
+		Key:    []byte("secret"),
+		Header: "X-Signature",
+		StringToSign: func(req *http.Request, body []byte) string {
+			return string(body)
+		},
+	}
+
+	client := New().SetSigner(signer)
+	client.BaseURL = ts.URL
+
+	// an io.Reader body can only be read once
+	if _, err := client.R().SetBody(io.NopCloser(strings.NewReader(payload))).Post("/"); err != nil {
+		t.Fatal(err)
+	}
+	if gotBody != payload {
+		t.Errorf("body = %q, want %q", gotBody, payload)
+	}
+	if want := zcrypto.HmacSHA256(payload, "secret"); gotSignature != want {
+		t.Errorf("X-Signature = %q, want %q", gotSignature, want)
+	}
+
+	// a retry sends the buffered body
+	attempts := 0
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts++; attempts == 1 {
+			panic(http.ErrAbortHandler)
+		}
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotSignature = r.Header.Get("X-Signature")
+	})
+	gotBody, gotSignature = "", ""
+	_, err := client.R().SetBody(strings.NewReader(payload)).ExecuteWithRetries(http.MethodPost, "/", WaitTime(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || gotBody != payload {
+		t.Errorf("%d attempts, body = %q, want 2, %q", attempts, gotBody, payload)
+	}
+	if want := zcrypto.HmacSHA256(payload, "secret"); gotSignature != want {
+		t.Errorf("X-Signature = %q, want %q", gotSignature, want)
+	}
+}
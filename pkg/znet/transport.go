@@ -2,23 +2,34 @@ package znet
 
 import (
 	"context"
+	"encoding/hex"
 	"net"
 	"net/http"
+	"net/url"
 	"runtime"
+	"strings"
 	"time"
 )
 
-func createTransport(localAddr net.Addr) *http.Transport {
-	dialer := &net.Dialer{
+const unixScheme = "unix://"
+
+// DialContextFunc dials a connection, with the same signature as net.Dialer.DialContext.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type unixSocketKey struct{}
+
+func newDialer(localAddr net.Addr) *net.Dialer {
+	return &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
+		LocalAddr: localAddr,
 	}
-	if localAddr != nil {
-		dialer.LocalAddr = localAddr
-	}
+}
+
+func createTransport(localAddr net.Addr) *http.Transport {
 	return &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           transportDialContext(dialer),
+		Proxy:                 transportProxy,
+		DialContext:           transportDialContext(newDialer(localAddr).DialContext),
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
@@ -28,6 +39,57 @@ func createTransport(localAddr net.Addr) *http.Transport {
 	}
 }
 
-func transportDialContext(dialer *net.Dialer) func(context.Context, string, string) (net.Conn, error) {
-	return dialer.DialContext
+// transportProxy is http.ProxyFromEnvironment, except for requests bound to a Unix domain socket.
+func transportProxy(req *http.Request) (*url.URL, error) {
+	if _, ok := req.Context().Value(unixSocketKey{}).(string); ok {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// transportDialContext dials with dial, except for requests bound to a Unix domain socket,
+// which dial the socket instead of the request host.
+func transportDialContext(dial DialContextFunc) DialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if socket, ok := ctx.Value(unixSocketKey{}).(string); ok {
+			return dial(ctx, "unix", socket)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// SetDialer sets the function used to open connections, e.g. to go through an SSH tunnel:
+//
+//	sshClient, _ := zssh.Dial(config)
+//	client.SetDialer(sshClient.DialContext)
+//
+// It applies to clients using an `*http.Transport`, which is cloned first so that a transport
+// shared with other clients is left unchanged. A nil dial restores the default dialer,
+// dialing from the local address of NewWithLocalAddr.
+func (c *Client) SetDialer(dial DialContextFunc) *Client {
+	t, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return c
+	}
+
+	if dial == nil {
+		dial = newDialer(c.localAddr).DialContext
+	}
+	t = t.Clone()
+	t.DialContext = transportDialContext(dial)
+	c.httpClient.Transport = t
+	return c
+}
+
+// unixSocketPath returns the socket path of a `unix:///path/to/socket` base URL.
+func unixSocketPath(baseURL string) (string, bool) {
+	if !strings.HasPrefix(baseURL, unixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(baseURL, unixScheme), true
+}
+
+// unixSocketHost returns the request host of a socket, its hex-encoded path, so that each socket has its own connections.
+func unixSocketHost(socket string) string {
+	return hex.EncodeToString([]byte(socket))
 }
@@ -0,0 +1,116 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_UnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	client := New()
+	for _, name := range []string{"a.sock", "b.sock", "a.sock"} {
+		socket := filepath.Join(dir, name)
+		if _, err := os.Stat(socket); err != nil {
+			l, err := net.Listen("unix", socket)
+			if err != nil {
+				t.Skip(err)
+			}
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(name + " " + r.Host + " " + r.URL.Path))
+			})}
+			go srv.Serve(l)
+			defer srv.Close()
+		}
+
+		// the connections of each socket are pooled apart
+		client.BaseURL = "unix://" + socket
+		resp, err := client.R().Get("/v1/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := name + " localhost /v1/status"; resp.String() != want {
+			t.Errorf("got %q, want %q", resp.String(), want)
+		}
+	}
+}
+
+func TestClient_SetDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	// every connection goes to the test server, whatever the request host
+	var dials atomic.Int32
+	client := New().SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", l.Addr().String())
+	})
+
+	resp, err := client.R().Get("http://agent.internal/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.String() != "agent.internal" || dials.Load() != 1 {
+		t.Errorf("got %q, %d dials", resp.String(), dials.Load())
+	}
+}
+
+func TestClient_SetDialerNil(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	// a free local port
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localAddr := pl.Addr().(*net.TCPAddr)
+	pl.Close()
+
+	// restoring the default dialer keeps the local address
+	client := NewWithLocalAddr(localAddr).SetDialer(nil)
+	resp, err := client.R().Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.String() != localAddr.String() {
+		t.Errorf("remote address %q, want %q", resp.String(), localAddr)
+	}
+}
+
+func TestClient_SetDialerSharedTransport(t *testing.T) {
+	shared := &http.Transport{}
+	client := NewWithClient(&http.Client{Transport: shared})
+
+	var dialed bool
+	client.SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = true
+		return nil, errors.New("dial refused")
+	})
+	if shared.DialContext != nil {
+		t.Error("shared transport was changed")
+	}
+	if _, err := client.R().Get("http://127.0.0.1:1"); err == nil || !dialed {
+		t.Errorf("dialer not used: %v", err)
+	}
+}
@@ -12,28 +12,7 @@ import (
 )
 
 func RunCommandWithTimeout(c *SSHConfig, cmd string, timeout time.Duration) ([]byte, error) {
-	pemBytes, err := os.ReadFile(findHomePath(c.IdentityFile))
-	if err != nil {
-		log.Fatalf("Failed to read private key file: %s", err)
-		return nil, err
-	}
-	signer, err := ssh.ParsePrivateKey(pemBytes)
-	if err != nil {
-		log.Fatalf("Failed to parse private key: %s", err)
-		return nil, err
-	}
-
-	config := &ssh.ClientConfig{
-		User: c.User,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}
-
-	// Create SSH client
-	client, err := ssh.Dial("tcp", net.JoinHostPort(c.HostName, c.Port), config)
+	client, err := Dial(c)
 	if err != nil {
 		log.Fatalf("Failed to dial: %s", err)
 		return nil, err
@@ -83,3 +62,27 @@ func RunCommandWithTimeout(c *SSHConfig, cmd string, timeout time.Duration) ([]b
 		return stdoutBuf.Bytes(), nil
 	}
 }
+
+// Dial connects to the host of c. The returned client can open tunneled connections with its DialContext method,
+// for example to be used as a `znet.Client` dialer.
+func Dial(c *SSHConfig) (*ssh.Client, error) {
+	pemBytes, err := os.ReadFile(findHomePath(c.IdentityFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User: c.User,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         30 * time.Second,
+	}
+
+	return ssh.Dial("tcp", net.JoinHostPort(c.HostName, c.Port), config)
+}